@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	pk "github.com/codcodea/cc/db"
+)
+
+// build.go holds the build-db subcommand, the maintenance pipeline for the color databases.
+// Usage: cc build-db [-manifest db/manifest.json] [-dry-run] [-diff [-threshold 1.0] [-fail-on-drift]]
+// It exits non-zero when a source file fails validation, so data regressions are caught.
+// With -diff it prints a drift report of every catalog against the previous target JSON.
+
+func BuildDB(args []string) int {
+	flags := flag.NewFlagSet("build-db", flag.ContinueOnError)
+	manifest := flags.String("manifest", "db/manifest.json", "path to the catalog manifest")
+	dryRun := flags.Bool("dry-run", false, "validate the sources without writing the targets")
+	diff := flags.Bool("diff", false, "report added, removed, renamed and shifted entries against the previous targets")
+	threshold := flags.Float64("threshold", 1.0, "CIEDE2000 ΔE (0-100 scale) above which an entry counts as shifted")
+	failOnDrift := flags.Bool("fail-on-drift", false, "with -diff, exit non-zero and keep the previous targets if any catalog changed")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	m, err := pk.LoadManifest(*manifest)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading manifest:", err)
+		return 1
+	}
+
+	opts := pk.BuildOptions{
+		DryRun:    *dryRun,
+		Diff:      *diff || *failOnDrift,
+		Threshold: *threshold,
+	}
+
+	// Check the drift before writing, so a failing build keeps the previous targets
+	if *failOnDrift && !*dryRun {
+		check := opts
+		check.DryRun = true
+		report, err := pk.Build(m, check)
+		if err == nil && report.Drifted() {
+			report.Print(os.Stdout)
+			fmt.Fprintln(os.Stderr, "Build failed: catalogs drifted from the previous targets")
+			return 1
+		}
+	}
+
+	report, err := pk.Build(m, opts)
+	report.Print(os.Stdout)
+
+	if errors.Is(err, pk.ErrValidation) {
+		fmt.Fprintln(os.Stderr, "Build failed:", err)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing targets:", err)
+		return 1
+	}
+	if *failOnDrift && report.Drifted() {
+		fmt.Fprintln(os.Stderr, "Build failed: catalogs drifted from the previous targets")
+		return 1
+	}
+	return 0
+}
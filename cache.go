@@ -0,0 +1,160 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cache.go holds the in-process LRU cache in front of getColor.
+// A color response only depends on the color, the options and the catalogs loaded at startup,
+// and the traffic is skewed to popular colors, so the rendered JSON is cached per request key.
+// The ETag is a hash of the body, browsers and CDNs revalidate with If-None-Match.
+
+// DefaultCacheSize is the number of cached color responses
+const DefaultCacheSize = 10000
+
+// ColorCache caches the rendered color responses, see colorJSON
+var ColorCache = NewLRU[string, CachedColor](DefaultCacheSize)
+
+// CachedColor is a rendered color response
+type CachedColor struct {
+	Body []byte
+	ETag string
+}
+
+// LRU is a size-bounded least recently used cache, safe for concurrent use
+type LRU[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[K]*list.Element
+	order    *list.List // front is the most recently used
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// CacheStats are the counters of a cache
+type CacheStats struct {
+	Capacity  int    `json:"capacity"`
+	Entries   int    `json:"entries"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// NewLRU creates a cache holding at most capacity entries, a capacity below 1 disables it
+func NewLRU[K comparable, V any](capacity int) *LRU[K, V] {
+	return &LRU[K, V]{
+		capacity: capacity,
+		items:    make(map[K]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the cached value of key and marks it as recently used
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		c.misses.Add(1)
+		var zero V
+		return zero, false
+	}
+	c.hits.Add(1)
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry[K, V]).value, true
+}
+
+// Add caches value under key, evicting the least recently used entry when full
+func (c *LRU[K, V]) Add(key K, value V) {
+	if c.capacity < 1 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		e.Value.(*lruEntry[K, V]).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry[K, V]{key, value})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[K, V]).key)
+		c.evictions.Add(1)
+	}
+}
+
+// Stats returns the counters of the cache
+func (c *LRU[K, V]) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.order.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Capacity:  c.capacity,
+		Entries:   entries,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+}
+
+// colorJSON returns the rendered response of a normalized color, from the cache if possible
+func colorJSON(color string, opts Options) (CachedColor, bool, error) {
+	key := cacheKey(color, opts)
+	if cached, ok := ColorCache.Get(key); ok {
+		return cached, true, nil
+	}
+
+	start := time.Now()
+	res, err := getColor(color, opts)
+	colorSeconds.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return CachedColor{}, false, err
+	}
+	body, err := json.Marshal(renderColor(res, opts))
+	if err != nil {
+		return CachedColor{}, false, err
+	}
+
+	h := fnv.New64a()
+	h.Write(body)
+	cached := CachedColor{Body: body, ETag: fmt.Sprintf(`"%016x"`, h.Sum64())}
+
+	ColorCache.Add(key, cached)
+	return cached, false, nil
+}
+
+// *** HELPER FUNCTIONS ***
+
+// cacheKey identifies a response by the normalized color and every option shaping it
+func cacheKey(color string, opts Options) string {
+	fields := opts.Fields
+	if fields == 0 {
+		fields = AllFields
+	}
+	locale := opts.Locale
+	if locale == "" {
+		locale = DefaultLocale
+	}
+	return strings.Join([]string{color, locale, strings.Join(opts.Pantone, ","), fields.String(), fmt.Sprint(opts.Compact)}, "|")
+}
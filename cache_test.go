@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestLRUEviction(t *testing.T) {
+	c := NewLRU[string, int](2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Get("a") // b is now the least recently used
+	c.Add("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s evicted", key)
+		}
+	}
+
+	want := CacheStats{Capacity: 2, Entries: 2, Hits: 3, Misses: 1, Evictions: 1}
+	if got := c.Stats(); got != want {
+		t.Errorf("stats %+v, want %+v", got, want)
+	}
+
+	// A capacity below 1 disables the cache
+	off := NewLRU[string, int](0)
+	off.Add("a", 1)
+	if _, ok := off.Get("a"); ok || off.Stats().Entries != 0 {
+		t.Error("disabled cache holds an entry")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	base := Options{Pantone: DefaultPantone, Locale: DefaultLocale}
+	variants := map[string]Options{
+		"fields":  {Pantone: DefaultPantone, Locale: DefaultLocale, Fields: FieldNames},
+		"lang":    {Pantone: DefaultPantone, Locale: "sv"},
+		"compact": {Pantone: DefaultPantone, Locale: DefaultLocale, Compact: true},
+		"pantone": {Pantone: []string{"pms", "coated"}, Locale: DefaultLocale},
+	}
+
+	key := cacheKey("#ff0000", base)
+	for name, opts := range variants {
+		if cacheKey("#ff0000", opts) == key {
+			t.Errorf("%s shares the cache entry of the defaults", name)
+		}
+	}
+	if cacheKey("#00ff00", base) == key {
+		t.Error("two colors share a cache entry")
+	}
+
+	// The zero options are the defaults
+	if got := cacheKey("#ff0000", Options{Pantone: DefaultPantone, Fields: AllFields}); got != key {
+		t.Errorf("key %s, want %s", got, key)
+	}
+}
+
+func TestHandleColorCache(t *testing.T) {
+	loadTestData(t)
+	defer func(c *LRU[string, CachedColor]) { ColorCache = c }(ColorCache)
+	ColorCache = NewLRU[string, CachedColor](10)
+
+	app := echo.New()
+	get := func(query string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/colors/ff0000"+query, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		c := app.NewContext(req, rec)
+		c.SetParamNames("hex")
+		c.SetParamValues("ff0000")
+		if err := HandleColor(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	first := get("", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || first.Header().Get("X-Cache") != "MISS" || etag == "" {
+		t.Fatalf("first request: %d, X-Cache %s, ETag %q", first.Code, first.Header().Get("X-Cache"), etag)
+	}
+
+	second := get("", nil)
+	if second.Header().Get("X-Cache") != "HIT" || second.Body.String() != first.Body.String() {
+		t.Errorf("second request: X-Cache %s, same body %v", second.Header().Get("X-Cache"), second.Body.String() == first.Body.String())
+	}
+
+	// Other fields and another locale are other entries with other bodies
+	for _, query := range []string{"?fields=names", "?lang=sv"} {
+		rec := get(query, nil)
+		if rec.Header().Get("X-Cache") != "MISS" || rec.Header().Get("ETag") == etag {
+			t.Errorf("%s: X-Cache %s, ETag %s of the default", query, rec.Header().Get("X-Cache"), rec.Header().Get("ETag"))
+		}
+	}
+
+	tests := []struct {
+		match string
+		code  int
+	}{
+		{etag, http.StatusNotModified},
+		{"W/" + etag, http.StatusNotModified},
+		{`"other", ` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"other"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := get("", http.Header{"If-None-Match": {tt.match}})
+		if rec.Code != tt.code {
+			t.Errorf("If-None-Match %s: %d, want %d", tt.match, rec.Code, tt.code)
+		}
+		if tt.code == http.StatusNotModified && (rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag) {
+			t.Errorf("If-None-Match %s: body of %d bytes, ETag %s", tt.match, rec.Body.Len(), rec.Header().Get("ETag"))
+		}
+	}
+
+	want := CacheStats{Capacity: 10, Entries: 3, Hits: 6, Misses: 3}
+	if got := ColorCache.Stats(); got != want {
+		t.Errorf("stats %+v, want %+v", got, want)
+	}
+}
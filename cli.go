@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"github.com/codcodea/cc/palette"
+	"github.com/codcodea/cc/types"
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// cli.go holds the lookup subcommands, the engine from the terminal and scripts without the server.
+// Usage:
+//
+//	cc color ff0000 [-fields names,ral] [-pantone pms] [-lang sv]
+//	cc name "sea green" [-limit 10] [-offset 0] [-lang sv]
+//	cc code ncs NCS_0502-B         (systems: ral, ncs, pantone)
+//	cc palette ff0000 -harmony triadic [-pantone pms] [-lang sv]
+//
+// Every subcommand takes -format table (default), json or csv and -data as the server, flags may follow the arguments.
+// The JSON of color is the response of GET /colors/:hex, the one of name the response of GET /names/search.
+
+// Harmonies maps the harmonies of cc palette to their hue rotations in LCh, "mono" is the gradient of getColor
+var Harmonies = map[string][]float64{
+	"complementary": {0, 180},
+	"analogous":     {0, -30, 30},
+	"triadic":       {0, 120, 240},
+	"split":         {0, 150, 210},
+	"tetradic":      {0, 90, 180, 270},
+	"mono":          nil,
+}
+
+// PaletteColor is a color of cc palette
+type PaletteColor struct {
+	Role string `json:"role"` // base, the hue rotation (e.g. +120) or the gradient step
+	types.Compact
+}
+
+// CodeMatch is the result of cc code
+type CodeMatch struct {
+	Catalog string           `json:"catalog"` // tree name, e.g. NCS or PAN
+	Record  types.JSONRecord `json:"record"`
+	Color   any              `json:"color"` // getColor of the record, shaped by -fields
+}
+
+// RunCLI runs a lookup subcommand, it returns the exit code
+func RunCLI(command string, args []string) int {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	format := flags.String("format", "table", "output format: table, json or csv")
+	flags.StringVar(&DataDir, "data", os.Getenv("CC_DATA_DIR"), "directory with catalog files overriding the embedded ones (layout of db/)")
+	lang := flags.String("lang", DefaultLocale, "locale of the names")
+
+	// Flags of the subcommands
+	fields := flags.String("fields", "", "color: comma separated sections, e.g. names,ral or -mono")
+	pantone := flags.String("pantone", "", "color, code, palette: comma separated Pantone catalogs to match")
+	limit := flags.Int("limit", 10, "name: number of results")
+	offset := flags.Int("offset", 0, "name: index of the first result")
+	harmony := flags.String("harmony", "complementary", "palette: complementary, analogous, triadic, split, tetradic or mono")
+
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return 2
+	}
+	if !slices.Contains([]string{"table", "json", "csv"}, *format) {
+		fmt.Fprintln(os.Stderr, "Unknown format:", *format)
+		return 2
+	}
+
+	// The catalogs load quietly, errors still go to stderr
+	SetupLogger("warn", "text")
+	if err := LoadTrees(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading KD trees:", err)
+		return 1
+	}
+	if err := LoadNameMap(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading color names:", err)
+		return 1
+	}
+
+	opts := Options{Locale: ResolveLocale(*lang, "")}
+	if opts.Pantone, err = ParsePantone(*pantone); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 2
+	}
+	if opts.Fields, err = ParseFields(*fields); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 2
+	}
+
+	var out output
+	switch command {
+	case "color":
+		out, err = cliColor(args, opts)
+	case "name":
+		out, err = cliName(args, opts, *offset, *limit)
+	case "code":
+		out, err = cliCode(args, opts)
+	case "palette":
+		out, err = cliPalette(args, opts, *harmony)
+	}
+
+	var usage usageError
+	if errors.As(err, &usage) {
+		fmt.Fprintln(os.Stderr, "Usage:", string(usage))
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	if err := out.write(os.Stdout, *format); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing output:", err)
+		return 1
+	}
+	return 0
+}
+
+// *** SUBCOMMANDS ***
+
+// cliColor looks up a hex color like GET /colors/:hex
+func cliColor(args []string, opts Options) (output, error) {
+	if len(args) != 1 {
+		return output{}, usageError("cc color <hex> [-fields names,ral] [-pantone pms] [-lang sv]")
+	}
+	hex, ok := normalizeHex(args[0])
+	if !ok {
+		return output{}, fmt.Errorf("color must be 6 hex digits: %s", args[0])
+	}
+
+	res, err := getColor(hex, opts)
+	if err != nil {
+		return output{}, err
+	}
+	return output{
+		value:  renderColor(res, opts),
+		header: []string{"field", "value"},
+		rows:   colorRows(res, opts.Fields),
+	}, nil
+}
+
+// cliName searches the color names like GET /names/search
+func cliName(args []string, opts Options, offset int, limit int) (output, error) {
+	query := strings.Join(args, " ")
+	if query == "" {
+		return output{}, usageError(`cc name "sea green" [-limit 10] [-offset 0] [-lang sv]`)
+	}
+	if limit < 1 || limit > maxSearchLimit || offset < 0 {
+		return output{}, fmt.Errorf("limit must be 1 to %d and offset 0 or more", maxSearchLimit)
+	}
+
+	session := NewFormSession(opts.Locale)
+	if err := ColorLookUp(context.Background(), query, session); err != nil {
+		return output{}, err
+	}
+	result := session.Result(offset, limit)
+
+	out := output{value: result, header: []string{"rank", "name", "hex"}}
+	for i, r := range result.Results {
+		out.rows = append(out.rows, []string{strconv.Itoa(offset + i + 1), r.Name, r.Hex})
+	}
+	return out, nil
+}
+
+// cliCode looks up a catalog code, e.g. NCS_0502-B, and the color of the record
+func cliCode(args []string, opts Options) (output, error) {
+	if len(args) != 2 {
+		return output{}, usageError("cc code <ral|ncs|pantone> <code>")
+	}
+
+	var trees []string
+	switch strings.ToLower(args[0]) {
+	case "ral":
+		trees = []string{RAL.Name}
+	case "ncs":
+		trees = []string{NCS.Name}
+	case "pantone", "pan":
+		for _, p := range PantoneCatalogs {
+			trees = append(trees, p.File.Name)
+		}
+	default:
+		return output{}, fmt.Errorf("unknown color system %q, use ral, ncs or pantone", args[0])
+	}
+
+	catalog, point, ok := findCode(trees, args[1])
+	if !ok {
+		return output{}, fmt.Errorf("no %s color with code %q", strings.ToUpper(args[0]), args[1])
+	}
+
+	record := extractToJson(point)
+	hex := record.Hex
+	if hex == "" {
+		hex = colorful.Lab(record.Lab.L, record.Lab.A, record.Lab.B).Clamped().Hex()
+	}
+
+	res, err := getColor(hex, opts)
+	if err != nil {
+		return output{}, err
+	}
+
+	rows := [][]string{{"code", record.Name}, {"catalog", catalog}}
+	if len(record.Aliases) > 0 {
+		rows = append(rows, []string{"aliases", strings.Join(record.Aliases, "; ")})
+	}
+	return output{
+		value:  CodeMatch{Catalog: catalog, Record: record, Color: renderColor(res, opts)},
+		header: []string{"field", "value"},
+		rows:   append(rows, colorRows(res, opts.Fields)...),
+	}, nil
+}
+
+// cliPalette builds a color harmony around a hex color, with the name and the catalog matches of every color
+func cliPalette(args []string, opts Options, harmony string) (output, error) {
+	if len(args) != 1 {
+		return output{}, usageError("cc palette <hex> [-harmony complementary|analogous|triadic|split|tetradic|mono]")
+	}
+	hex, ok := normalizeHex(args[0])
+	if !ok {
+		return output{}, fmt.Errorf("color must be 6 hex digits: %s", args[0])
+	}
+	rotations, ok := Harmonies[harmony]
+	if !ok {
+		return output{}, fmt.Errorf("unknown harmony %q", harmony)
+	}
+
+	// The roles and hex colors of the palette
+	var roles, colors []string
+	if harmony == "mono" {
+		gradient, err := palette.NatrualGradient(hex)
+		if err != nil {
+			return output{}, err
+		}
+		for i, g := range gradient.Gradient {
+			roles = append(roles, strconv.Itoa(i+1))
+			colors = append(colors, g.Hex)
+		}
+	} else {
+		base, _ := colorful.Hex(hex)
+		h, c, l := base.Hcl()
+		for _, r := range rotations {
+			role := "base"
+			if r != 0 {
+				role = fmt.Sprintf("%+g", r)
+			}
+			roles = append(roles, role)
+			colors = append(colors, colorful.Hcl(h+r, c, l).Clamped().Hex())
+		}
+	}
+
+	fields := FieldBase | FieldRAL | FieldPAN | FieldNCS
+	opts.Fields = fields
+
+	var result []PaletteColor
+	out := output{header: []string{"role", "hex", "name", "ral", "pan", "ncs"}}
+	for i, color := range colors {
+		res, err := getColor(color, opts)
+		if err != nil {
+			return output{}, err
+		}
+		compact := compactColor(res, fields)
+		result = append(result, PaletteColor{Role: roles[i], Compact: compact})
+		out.rows = append(out.rows, []string{roles[i], compact.Color, compact.Name, compact.RAL, compact.PAN, compact.NCS})
+	}
+	out.value = result
+	return out, nil
+}
+
+// *** HELPER FUNCTIONS ***
+
+// output is the result of a subcommand, value is written as JSON, the rows as a table or CSV
+type output struct {
+	value  any
+	header []string
+	rows   [][]string
+}
+
+// usageError is the usage of a subcommand called with the wrong arguments
+type usageError string
+
+func (u usageError) Error() string {
+	return "usage: " + string(u)
+}
+
+func (o output) write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(o.value)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(o.header)
+		cw.WriteAll(o.rows) // flushes
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(o.header, "\t")))
+		for _, row := range o.rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+}
+
+// colorRows lists the selected sections of a getColor result as field, value rows
+func colorRows(res types.Response, fields Fields) [][]string {
+	if fields == 0 {
+		fields = AllFields
+	}
+	conv := res.Conversion
+
+	rows := [][]string{{"color", res.Base.Color.Color}}
+	if fields.Has(FieldBase) {
+		rows = append(rows, []string{"name", res.Base.Name}, []string{"description", res.Base.Description})
+	}
+	if fields.Has(FieldNames) {
+		rows = append(rows, []string{"names", strings.Join(res.Names, "; ")})
+	}
+	if fields.Has(FieldConversions) {
+		rows = append(rows,
+			[]string{"rgb", conv.RGB}, []string{"hsl", conv.HSL}, []string{"hsv", conv.HSV},
+			[]string{"lab", conv.LAB}, []string{"cmyk", conv.CMYK})
+	}
+	if fields.Has(FieldRAL) {
+		rows = append(rows, []string{"ral", matchValue(conv.RAL.Name, conv.RAL.Distance)})
+	}
+	if fields.Has(FieldPAN) {
+		rows = append(rows, []string{"pan", strings.TrimSpace(matchValue(conv.PAN.Name, conv.PAN.Distance) + " " + conv.PAN.Catalog)})
+	}
+	if fields.Has(FieldNCS) {
+		rows = append(rows, []string{"ncs", matchValue(conv.NCS.Name, conv.NCS.Distance)})
+	}
+	if fields.Has(FieldMono) {
+		var mono []string
+		for _, m := range res.Mono {
+			mono = append(mono, m.Color.Color+" "+m.Color.Name)
+		}
+		rows = append(rows, []string{"mono", strings.Join(mono, "; ")})
+	}
+	return rows
+}
+
+// matchValue formats a catalog match with its CIEDE2000 distance
+func matchValue(name string, distance float64) string {
+	return fmt.Sprintf("%s (ΔE %.2f)", name, distance*100)
+}
+
+// findCode returns the first point of the trees whose name, code or alias is code.
+// Codes compare without case, spaces and punctuation, and the system prefix is optional: "ncs 0502-b" is NCS_0502-B.
+func findCode(trees []string, code string) (string, types.CustomPoint, bool) {
+	want := codeKey(code)
+	for _, name := range trees {
+		tree, ok := Trees[name]
+		if !ok {
+			continue
+		}
+		for _, p := range tree.Points() {
+			point := p.(types.CustomPoint)
+			keys := []string{point.Name}
+			if point.Meta != nil {
+				keys = append(keys, point.Meta.Code)
+				keys = append(keys, point.Meta.Aliases...)
+			}
+			for _, k := range keys {
+				if key := codeKey(k); key != "" && (key == want || strings.TrimLeftFunc(key, unicode.IsLetter) == want) {
+					return name, point, true
+				}
+			}
+		}
+	}
+	return "", types.CustomPoint{}, false
+}
+
+// codeKey normalizes a code for findCode
+func codeKey(code string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, code)
+}
+
+// parseInterspersed parses the flags wherever they are in args and returns the other arguments
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return rest, nil
+		}
+		rest = append(rest, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/bytes"
+)
+
+// config.go holds the configuration of the server.
+// Every setting is a flag, an environment variable and a key of the optional JSON config file,
+// the file keys are the flag names, e.g. {"addr": ":443", "cors-origins": ["https://mycolorpicker.com"], "read-timeout": "5s"}.
+// Precedence: flags, then the environment, then the config file, then the defaults.
+
+// Config is the configuration of the server
+type Config struct {
+	DataDir   string
+	CacheSize int
+	LogLevel  string
+	LogFormat string
+
+	Addr            string
+	TLSCert         string // TLS is served when both the cert and the key are set
+	TLSKey          string
+	CORSOrigins     []string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration // of HTTP responses, websocket sessions have no deadline
+	IdleTimeout     time.Duration
+	MaxBody         string // e.g. 64K or 1M
+	ShutdownTimeout time.Duration
+
+	RateLimit      float64 // tokens per second of a client without API key, 0 disables the limit
+	RateBurst      int
+	APIKeysFile    string // see ratelimit.go
+	RequireAPIKey  bool
+	TrustedProxies []string // CIDRs of the proxies whose X-Forwarded-For is believed, none by default
+
+	WSMaxSessions int // see socket.go
+	WSMaxMessage  string
+	WSRate        float64
+	WSBurst       int
+	WSHeartbeat   time.Duration
+	WSIdleTimeout time.Duration
+}
+
+// DefaultConfig returns the defaults of the configuration
+func DefaultConfig() Config {
+	return Config{
+		CacheSize:       DefaultCacheSize,
+		LogLevel:        "info",
+		LogFormat:       "json",
+		Addr:            ":4005",
+		CORSOrigins:     []string{"*"}, // add mycolorpicker.com in production
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    30 * time.Second,
+		IdleTimeout:     2 * time.Minute,
+		MaxBody:         "64K",
+		ShutdownTimeout: 15 * time.Second,
+		RateLimit:       20,
+		RateBurst:       40,
+		WSMaxSessions:   1000,
+		WSMaxMessage:    "4K",
+		WSRate:          20,
+		WSBurst:         40,
+		WSHeartbeat:     30 * time.Second,
+		WSIdleTimeout:   5 * time.Minute,
+	}
+}
+
+// configEnv maps the flags to their environment variables
+var configEnv = map[string]string{
+	"config":           "CC_CONFIG",
+	"data":             "CC_DATA_DIR",
+	"cache-size":       "CC_CACHE_SIZE",
+	"log-level":        "CC_LOG_LEVEL",
+	"log-format":       "CC_LOG_FORMAT",
+	"addr":             "CC_ADDR",
+	"tls-cert":         "CC_TLS_CERT",
+	"tls-key":          "CC_TLS_KEY",
+	"cors-origins":     "CC_CORS_ORIGINS",
+	"read-timeout":     "CC_READ_TIMEOUT",
+	"write-timeout":    "CC_WRITE_TIMEOUT",
+	"idle-timeout":     "CC_IDLE_TIMEOUT",
+	"max-body":         "CC_MAX_BODY",
+	"shutdown-timeout": "CC_SHUTDOWN_TIMEOUT",
+	"rate-limit":       "CC_RATE_LIMIT",
+	"rate-burst":       "CC_RATE_BURST",
+	"api-keys":         "CC_API_KEYS",
+	"require-api-key":  "CC_REQUIRE_API_KEY",
+	"trusted-proxies":  "CC_TRUSTED_PROXIES",
+	"ws-max-sessions":  "CC_WS_MAX_SESSIONS",
+	"ws-max-message":   "CC_WS_MAX_MESSAGE",
+	"ws-rate":          "CC_WS_RATE",
+	"ws-burst":         "CC_WS_BURST",
+	"ws-heartbeat":     "CC_WS_HEARTBEAT",
+	"ws-idle-timeout":  "CC_WS_IDLE_TIMEOUT",
+}
+
+// LoadConfig reads the configuration from the command line args, the environment and the config file
+func LoadConfig(args []string) (Config, error) {
+	cfg := DefaultConfig()
+
+	flags := flag.NewFlagSet("cc", flag.ContinueOnError)
+	configFile := flags.String("config", "", "JSON config file, its keys are the flag names")
+	flags.StringVar(&cfg.DataDir, "data", cfg.DataDir, "directory with catalog files overriding the embedded ones (layout of db/)")
+	flags.IntVar(&cfg.CacheSize, "cache-size", cfg.CacheSize, "number of cached color responses, 0 disables the cache")
+	flags.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: debug, info, warn or error")
+	flags.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log format: json or text")
+	flags.StringVar(&cfg.Addr, "addr", cfg.Addr, "listen address")
+	flags.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "TLS certificate file, serves HTTPS with -tls-key")
+	flags.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "TLS private key file")
+	flags.Var((*listValue)(&cfg.CORSOrigins), "cors-origins", "comma separated origins allowed by CORS, * allows any")
+	flags.DurationVar(&cfg.ReadTimeout, "read-timeout", cfg.ReadTimeout, "time to read a request, 0 for none")
+	flags.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "time to write a response, 0 for none")
+	flags.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout, "time a keep-alive connection waits for the next request")
+	flags.StringVar(&cfg.MaxBody, "max-body", cfg.MaxBody, "largest request body, e.g. 64K or 1M")
+	flags.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "time to drain the requests on SIGTERM")
+	flags.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "tokens per second of a client without API key, a color lookup takes 1, 0 disables")
+	flags.IntVar(&cfg.RateBurst, "rate-burst", cfg.RateBurst, "token bucket size of a client without API key")
+	flags.StringVar(&cfg.APIKeysFile, "api-keys", cfg.APIKeysFile, "JSON file of the API keys with their quotas and origins")
+	flags.BoolVar(&cfg.RequireAPIKey, "require-api-key", cfg.RequireAPIKey, "reject the requests without API key")
+	flags.Var((*listValue)(&cfg.TrustedProxies), "trusted-proxies", "comma separated CIDRs of the proxies whose X-Forwarded-For gives the client IP, none by default")
+	flags.IntVar(&cfg.WSMaxSessions, "ws-max-sessions", cfg.WSMaxSessions, "open websocket sessions, 0 for no limit")
+	flags.StringVar(&cfg.WSMaxMessage, "ws-max-message", cfg.WSMaxMessage, "largest websocket client message, e.g. 4K")
+	flags.Float64Var(&cfg.WSRate, "ws-rate", cfg.WSRate, "client messages per second of a lookup socket session, 0 for no limit (the color stream coalesces them)")
+	flags.IntVar(&cfg.WSBurst, "ws-burst", cfg.WSBurst, "client messages a lookup socket session may send at once")
+	flags.DurationVar(&cfg.WSHeartbeat, "ws-heartbeat", cfg.WSHeartbeat, "interval of the websocket pings, 0 disables them")
+	flags.DurationVar(&cfg.WSIdleTimeout, "ws-idle-timeout", cfg.WSIdleTimeout, "closes a websocket session without client message for this long, 0 for never")
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+	if flags.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	// The flags are applied again last, so they win over the environment and the file
+	given := map[string]string{}
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = f.Value.String() })
+	cfg = DefaultConfig()
+
+	path := *configFile
+	if _, ok := given["config"]; !ok {
+		path = os.Getenv(configEnv["config"])
+	}
+	if path != "" {
+		if err := readConfigFile(flags, path); err != nil {
+			return cfg, err
+		}
+	}
+
+	for name, env := range configEnv {
+		if v, ok := os.LookupEnv(env); ok && name != "config" {
+			if err := flags.Set(name, v); err != nil {
+				return cfg, fmt.Errorf("bad %s: %w", env, err)
+			}
+		}
+	}
+
+	for name, v := range given {
+		flags.Set(name, v)
+	}
+
+	return cfg, cfg.validate()
+}
+
+// TLS reports whether the server serves HTTPS
+func (cfg Config) TLS() bool {
+	return cfg.TLSCert != "" && cfg.TLSKey != ""
+}
+
+// SocketLimits returns the limits of the websocket sessions
+func (cfg Config) SocketLimits() SocketLimits {
+	maxMessage, _ := bytes.Parse(cfg.WSMaxMessage) // checked by validate
+	return SocketLimits{
+		MaxSessions: cfg.WSMaxSessions,
+		MaxMessage:  int(maxMessage),
+		Rate:        cfg.WSRate,
+		Burst:       cfg.WSBurst,
+		Heartbeat:   cfg.WSHeartbeat,
+		IdleTimeout: cfg.WSIdleTimeout,
+		Origins:     cfg.CORSOrigins,
+	}
+}
+
+// IPExtractor returns how the client IP of a request is found, for the rate limit and the access log.
+// Without trusted proxies it's the address of the connection, X-Forwarded-For could be spoofed by any client.
+func (cfg Config) IPExtractor() echo.IPExtractor {
+	if len(cfg.TrustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	// echo trusts the loopback, link-local and private ranges by default, only the configured ones are
+	trust := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, p := range cfg.TrustedProxies {
+		_, ipNet, _ := net.ParseCIDR(proxyCIDR(p)) // checked by validate
+		trust = append(trust, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(trust...)
+}
+
+// *** HELPER FUNCTIONS ***
+
+func (cfg Config) validate() error {
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return errors.New("tls-cert and tls-key must be set together")
+	}
+	if len(cfg.CORSOrigins) == 0 {
+		return errors.New("cors-origins is empty, use * to allow any origin")
+	}
+	if cfg.RateLimit < 0 || (cfg.RateLimit > 0 && cfg.RateBurst < 1) {
+		return errors.New("rate-limit must be 0 or more, with a rate-burst of 1 or more")
+	}
+	if _, err := bytes.Parse(cfg.MaxBody); err != nil {
+		return fmt.Errorf("bad max-body %q: %w", cfg.MaxBody, err)
+	}
+	if _, err := bytes.Parse(cfg.WSMaxMessage); err != nil {
+		return fmt.Errorf("bad ws-max-message %q: %w", cfg.WSMaxMessage, err)
+	}
+	for _, p := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxyCIDR(p)); err != nil {
+			return fmt.Errorf("bad trusted-proxies %q: %w", p, err)
+		}
+	}
+	if cfg.WSMaxSessions < 0 || cfg.WSRate < 0 || cfg.WSBurst < 0 {
+		return errors.New("ws-max-sessions, ws-rate and ws-burst must be 0 or more")
+	}
+	for name, d := range map[string]time.Duration{
+		"read-timeout":     cfg.ReadTimeout,
+		"write-timeout":    cfg.WriteTimeout,
+		"idle-timeout":     cfg.IdleTimeout,
+		"shutdown-timeout": cfg.ShutdownTimeout,
+		"ws-heartbeat":     cfg.WSHeartbeat,
+		"ws-idle-timeout":  cfg.WSIdleTimeout,
+	} {
+		if d < 0 {
+			return fmt.Errorf("%s is negative", name)
+		}
+	}
+	return nil
+}
+
+// proxyCIDR returns a trusted proxy as a CIDR, a single address is its own range
+func proxyCIDR(p string) string {
+	if strings.Contains(p, "/") {
+		return p
+	}
+	if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+		return p + "/32"
+	}
+	return p + "/128"
+}
+
+// readConfigFile sets the flags from a JSON config file
+func readConfigFile(flags *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	var values map[string]any
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "config" || flags.Lookup(name) == nil {
+			return fmt.Errorf("config %s: unknown setting %q", path, name)
+		}
+
+		var v string
+		switch value := values[name].(type) {
+		case string:
+			v = value
+		case float64, bool:
+			v = fmt.Sprint(value)
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			v = strings.Join(items, ",")
+		default:
+			return fmt.Errorf("config %s: bad value of %q", path, name)
+		}
+
+		if err := flags.Set(name, v); err != nil {
+			return fmt.Errorf("config %s: bad %s: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// listValue is a comma separated flag
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(v string) error {
+	*l = nil
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cc.json")
+	os.WriteFile(file, []byte(`{"addr": ":1000", "ws-rate": 5, "cors-origins": ["https://a.example", "https://b.example"]}`), 0o644)
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		addr string
+		file bool // the other settings come from the file
+	}{
+		{"default", nil, nil, ":4005", false},
+		{"file", []string{"-config", file}, nil, ":1000", true},
+		{"file from env", nil, map[string]string{"CC_CONFIG": file}, ":1000", true},
+		{"env over file", []string{"-config", file}, map[string]string{"CC_ADDR": ":2000"}, ":2000", true},
+		{"env", nil, map[string]string{"CC_ADDR": ":2000"}, ":2000", false},
+		{"flag over env", []string{"-addr", ":3000"}, map[string]string{"CC_ADDR": ":2000"}, ":3000", false},
+		{"flag over env and file", []string{"-addr", ":3000", "-config", file}, map[string]string{"CC_ADDR": ":2000"}, ":3000", true},
+		{"flag before the file flag", []string{"-config", file, "-addr", ":3000"}, nil, ":3000", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			cfg, err := LoadConfig(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Addr != tt.addr {
+				t.Errorf("addr %s, want %s", cfg.Addr, tt.addr)
+			}
+
+			// The settings not overridden keep the value of the lower layer
+			if want := DefaultConfig().WSRate; tt.file {
+				if cfg.WSRate != 5 || len(cfg.CORSOrigins) != 2 {
+					t.Errorf("ws-rate %g and cors-origins %v, want the file values", cfg.WSRate, cfg.CORSOrigins)
+				}
+			} else if cfg.WSRate != want {
+				t.Errorf("ws-rate %g, want the default %g", cfg.WSRate, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	clearConfigEnv(t)
+	dir := t.TempDir()
+	unknown := filepath.Join(dir, "unknown.json")
+	os.WriteFile(unknown, []byte(`{"adr": ":1000"}`), 0o644)
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{"unknown file key", []string{"-config", unknown}, nil},
+		{"missing file", []string{"-config", filepath.Join(dir, "none.json")}, nil},
+		{"bad env", nil, map[string]string{"CC_RATE_LIMIT": "fast"}},
+		{"tls cert without key", []string{"-tls-cert", "cert.pem"}, nil},
+		{"argument", []string{"serve"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if _, err := LoadConfig(tt.args); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestServeClosesSockets(t *testing.T) {
+	defer func(s *socketSet, ready bool) {
+		Sockets = s
+		loadState.ready.Store(ready)
+	}(Sockets, loadState.ready.Load())
+	Sockets = &socketSet{conns: make(map[*Socket]struct{})}
+	Sockets.Configure(SocketLimits{Origins: []string{"*"}})
+
+	app := echo.New()
+	app.HideBanner, app.HidePort = true, true
+	app.GET("/ws", func(c echo.Context) error {
+		return ServeSocket(c, "lookup", func(sock *Socket) {
+			for {
+				if _, err := sock.Receive(); err != nil {
+					return
+				}
+			}
+		})
+	})
+
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.ShutdownTimeout = 5 * time.Second
+
+	served := make(chan error, 1)
+	go func() { served <- Serve(app, cfg) }()
+
+	// Serve listens once it handles the signals
+	var addr string
+	for i := 0; i < 100 && addr == ""; i++ {
+		if a := app.ListenerAddr(); a != nil {
+			addr = a.String()
+		} else {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if addr == "" {
+		t.Fatal("the server is not listening")
+	}
+
+	ws, err := websocket.Dial("ws://"+addr+"/ws", "", "http://"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	for i := 0; i < 100 && openSockets() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(cfg.ShutdownTimeout):
+		t.Fatal("Serve did not return")
+	}
+
+	// The session got a close frame and is no longer tracked
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	var msg string
+	if err := websocket.Message.Receive(ws, &msg); !errors.Is(err, io.EOF) {
+		t.Errorf("read after shutdown: %v, want EOF", err)
+	}
+	if open := openSockets(); open != 0 {
+		t.Errorf("%d sessions still open", open)
+	}
+	if loadState.ready.Load() {
+		t.Error("still ready after shutdown")
+	}
+
+	if _, err := http.Get("http://" + addr + "/ws"); err == nil {
+		t.Error("the server still accepts connections")
+	}
+}
+
+// clearConfigEnv unsets the environment variables of the configuration for the test
+func clearConfigEnv(t *testing.T) {
+	for _, env := range configEnv {
+		t.Setenv(env, "") // restored after the test
+		os.Unsetenv(env)
+	}
+}
+
+// openSockets returns the number of tracked websocket sessions
+func openSockets() int {
+	Sockets.mu.Lock()
+	defer Sockets.mu.Unlock()
+	return len(Sockets.conns)
+}
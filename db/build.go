@@ -0,0 +1,376 @@
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	ty "github.com/codcodea/cc/types"
+)
+
+// build.go runs the cleaners for every catalog listed in the manifest (db/manifest.json),
+// validates the records and writes the db/target JSON files and their KD tree snapshots (snapshot.go).
+// Targets are only written when no catalog has errors, each one atomically (temp file + rename),
+// so a failed build never leaves a half written catalog behind.
+
+// Manifest lists the catalogs to build. Paths are relative to the manifest file.
+type Manifest struct {
+	Source   string    `json:"source"` // source directory, e.g. "source"
+	Target   string    `json:"target"` // target directory, e.g. "target"
+	Catalogs []Catalog `json:"catalogs"`
+
+	dir string // directory of the manifest file
+}
+
+// Catalog is one catalog of the manifest
+type Catalog struct {
+	Name     string   `json:"name"` // tree name used by the server, e.g. "RAL"
+	Kind     string   `json:"kind"` // cleaner to run, see Cleaners
+	Source   string   `json:"source"`
+	Target   string   `json:"target"`
+	Src      string   `json:"src,omitempty"` // written to every record
+	License  string   `json:"license,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Optional bool     `json:"optional,omitempty"` // skip when the source file is missing
+
+	// Selection by the server
+	Variant string `json:"variant,omitempty"` // Pantone sub-catalog of kind pantone, e.g. "pms" for ?pantone=
+	Locale  string `json:"locale,omitempty"`  // name catalog of kind names for ?lang=, e.g. "sv"
+}
+
+// Issue is a validation problem found in a source file
+type Issue struct {
+	Line    int    `json:"line"`  // 0 when the issue is not tied to a row
+	Level   string `json:"level"` // "error" or "warning"
+	Message string `json:"message"`
+}
+
+// CatalogReport is the build result of one catalog
+type CatalogReport struct {
+	Name    string  `json:"name"`
+	Source  string  `json:"source"`
+	Target  string  `json:"target"`
+	Rows    int     `json:"rows"`    // data rows read from the source
+	Records int     `json:"records"` // records written to the target
+	Skipped bool    `json:"skipped"`
+	Issues  []Issue `json:"issues"`
+
+	Diff *DiffReport `json:"diff,omitempty"` // drift against the previous target, see diff.go
+
+	records []ColorData
+}
+
+// Report is the build result of all catalogs
+type Report struct {
+	Catalogs []*CatalogReport `json:"catalogs"`
+	Written  bool             `json:"written"`
+}
+
+// BuildOptions controls Build
+type BuildOptions struct {
+	DryRun    bool    // validate only, do not write the targets
+	Diff      bool    // compare with the previous targets
+	Threshold float64 // ΔE above which an entry is reported as shifted
+}
+
+// ErrValidation is returned by Build when at least one catalog has errors
+var ErrValidation = errors.New("catalog validation failed")
+
+// LoadManifest reads a build manifest from path
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := parseManifest(data, path)
+	if err != nil {
+		return nil, err
+	}
+	m.dir = filepath.Dir(path)
+	return m, nil
+}
+
+// DataPath returns the path of the target of c in a data directory laid out like db/, see ReadData
+func (m *Manifest) DataPath(c Catalog) string {
+	return path.Join(m.Target, c.Target)
+}
+
+// SourcePath returns the path of the source file of c
+func (m *Manifest) SourcePath(c Catalog) string {
+	return filepath.Join(m.dir, m.Source, c.Source)
+}
+
+// TargetPath returns the path of the target file of c
+func (m *Manifest) TargetPath(c Catalog) string {
+	return filepath.Join(m.dir, m.Target, c.Target)
+}
+
+// Build runs all cleaners of the manifest and validates the records.
+// With opts.Diff each catalog is compared with its previous target before it is replaced.
+// Unless opts.DryRun is set and if there are no errors the targets are written.
+// It returns ErrValidation if any catalog has errors, the report is always returned.
+func Build(m *Manifest, opts BuildOptions) (*Report, error) {
+	report := new(Report)
+
+	for _, c := range m.Catalogs {
+		r := &CatalogReport{
+			Name:   c.Name,
+			Source: m.SourcePath(c),
+			Target: m.TargetPath(c),
+			Issues: []Issue{},
+		}
+		report.Catalogs = append(report.Catalogs, r)
+
+		if _, err := os.Stat(r.Source); err != nil && c.Optional {
+			r.Skipped = true
+			continue
+		}
+
+		records := Cleaners[c.Kind](r.Source, r)
+		records = dedupe(records, r)
+
+		for i := range records {
+			applyCatalogMeta(&records[i], c)
+		}
+		if len(records) == 0 {
+			r.Errorf(0, "no records")
+		}
+
+		r.records = records
+		r.Records = len(records)
+
+		if opts.Diff {
+			previous, err := LoadTarget(r.Target)
+			if err != nil && !os.IsNotExist(err) {
+				r.Warnf(0, "previous target not compared: %v", err)
+			}
+			if err != nil {
+				r.Diff = &DiffReport{Threshold: opts.Threshold}
+			} else {
+				r.Diff = Diff(previous, records, opts.Threshold)
+			}
+		}
+	}
+
+	if report.HasErrors() {
+		return report, ErrValidation
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+
+	for _, r := range report.Catalogs {
+		if r.Skipped {
+			continue
+		}
+		data, err := writeJSON(r.Target, r.records)
+		if err != nil {
+			return report, err
+		}
+		if err := writeSnapshot(SnapshotPath(r.Target), r.records, data); err != nil {
+			return report, err
+		}
+	}
+	report.Written = true
+	return report, nil
+}
+
+// Drifted reports whether any catalog changed compared to its previous target
+func (r *Report) Drifted() bool {
+	for _, c := range r.Catalogs {
+		if c.Diff != nil && c.Diff.Previous && c.Diff.Changed() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasErrors reports whether any catalog has an error
+func (r *Report) HasErrors() bool {
+	for _, c := range r.Catalogs {
+		if c.HasErrors() {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes a human readable summary of the report to w
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "%-10s %8s %8s %8s %8s  %s\n", "CATALOG", "ROWS", "RECORDS", "ERRORS", "WARNINGS", "TARGET")
+	for _, c := range r.Catalogs {
+		if c.Skipped {
+			fmt.Fprintf(w, "%-10s %8s %8s %8s %8s  %s\n", c.Name, "-", "-", "-", "-", "skipped, no source")
+			continue
+		}
+		errs, warns := c.count()
+		fmt.Fprintf(w, "%-10s %8d %8d %8d %8d  %s\n", c.Name, c.Rows, c.Records, errs, warns, c.Target)
+	}
+
+	for _, c := range r.Catalogs {
+		for _, i := range c.Issues {
+			fmt.Fprintf(w, "%s %s:%d: %s\n", i.Level, c.Source, i.Line, i.Message)
+		}
+	}
+
+	for _, c := range r.Catalogs {
+		if c.Diff != nil {
+			c.Diff.Print(w, c.Name, 20)
+		}
+	}
+
+	if r.Written {
+		fmt.Fprintln(w, "Targets written.")
+	} else {
+		fmt.Fprintln(w, "Targets not written.")
+	}
+}
+
+// Errorf adds an error for a source line (0 if not tied to a row)
+func (r *CatalogReport) Errorf(line int, format string, args ...any) {
+	r.Issues = append(r.Issues, Issue{line, "error", fmt.Sprintf(format, args...)})
+}
+
+// Warnf adds a warning for a source line (0 if not tied to a row)
+func (r *CatalogReport) Warnf(line int, format string, args ...any) {
+	r.Issues = append(r.Issues, Issue{line, "warning", fmt.Sprintf(format, args...)})
+}
+
+// HasErrors reports whether the catalog has an error
+func (r *CatalogReport) HasErrors() bool {
+	errs, _ := r.count()
+	return errs > 0
+}
+
+func (r *CatalogReport) count() (errs int, warns int) {
+	for _, i := range r.Issues {
+		if i.Level == "error" {
+			errs++
+		} else {
+			warns++
+		}
+	}
+	return errs, warns
+}
+
+// *** HELPER FUNCTIONS ***
+
+// parseManifest parses and checks a manifest, name is the file for the errors
+func parseManifest(data []byte, name string) (*Manifest, error) {
+	m := new(Manifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parse manifest %s: %w", name, err)
+	}
+
+	variants, locales := make(map[string]bool), make(map[string]bool)
+	for _, c := range m.Catalogs {
+		if _, ok := Cleaners[c.Kind]; !ok {
+			return nil, fmt.Errorf("manifest %s: catalog %s has unknown kind %q", name, c.Name, c.Kind)
+		}
+		if c.Variant != "" && (c.Kind != "pantone" || variants[c.Variant]) {
+			return nil, fmt.Errorf("manifest %s: catalog %s has a bad or repeated variant %q", name, c.Name, c.Variant)
+		}
+		if c.Locale != "" && (c.Kind != "names" || locales[c.Locale]) {
+			return nil, fmt.Errorf("manifest %s: catalog %s has a bad or repeated locale %q", name, c.Name, c.Locale)
+		}
+		variants[c.Variant], locales[c.Locale] = true, true
+	}
+	return m, nil
+}
+
+// dedupe drops repeated names. Exact repeats are a warning,
+// the same name with a different color is an error.
+func dedupe(records []ColorData, r *CatalogReport) []ColorData {
+	seen := make(map[string]ColorData, len(records))
+	out := records[:0]
+
+	for _, c := range records {
+		prev, ok := seen[c.Name]
+		if !ok {
+			seen[c.Name] = c
+			out = append(out, c)
+			continue
+		}
+		if prev.Lab == c.Lab {
+			r.Warnf(c.Line, "duplicate entry %q dropped, first at line %d", c.Name, prev.Line)
+		} else {
+			r.Errorf(c.Line, "duplicate name %q with different colors, first at line %d", c.Name, prev.Line)
+		}
+	}
+	return out
+}
+
+// applyCatalogMeta sets the catalog wide metadata of the manifest on a record
+func applyCatalogMeta(c *ColorData, catalog Catalog) {
+	if catalog.Src != "" {
+		src := catalog.Src
+		c.Src = &src
+	}
+	if catalog.License != "" {
+		license := catalog.License
+		c.License = &license
+	}
+	for _, t := range catalog.Tags {
+		if !contains(c.Tags, t) {
+			c.Tags = append(c.Tags, t)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON writes v as indented JSON to path, atomically, and returns the written data
+func writeJSON(path string, v any) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return data, WriteFileAtomic(path, data)
+}
+
+// writeSnapshot writes the balanced KD tree snapshot of the records to path, atomically
+func writeSnapshot(path string, records []ColorData, source []byte) error {
+	points := make([]ty.CustomPoint, len(records))
+	for i, c := range records {
+		points[i] = c.Point()
+	}
+	return WriteFileAtomic(path, EncodeSnapshot(points, source))
+}
+
+// WriteFileAtomic writes data to a temp file next to path and renames it into place
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
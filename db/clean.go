@@ -3,27 +3,18 @@ package io
 import (
 	"bufio"
 	"encoding/csv"
-	"encoding/json"
-	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/lucasb-eyer/go-colorful"
 )
 
-var fileNAME = "/Users/fhalsius/Documents/Code/go/mycolorpicker/io/source/colornames.bestof.csv"
-var fileNAMAtarget = "/Users/fhalsius/Documents/Code/go/mycolorpicker/io/target/colornames.json"
-
-var fileRAL = "/Users/fhalsius/Documents/Code/go/mycolorpicker/io/source/RAL_PLUS_CIELAB1931_sRGB.csv"
-var fileRALtarget = "/Users/fhalsius/Documents/Code/go/mycolorpicker/io/target/RAL_PLUS_CIELAB1931_sRGB.json"
-
-var filePAN = "/Users/fhalsius/Documents/Code/go/mycolorpicker/io/source/pantone.txt"
-var filePANtarget = "/Users/fhalsius/Documents/Code/go/mycolorpicker/io/target/pantone.json"
-
-var fileNCS = "/Users/fhalsius/Documents/Code/go/mycolorpicker/io/source/ncs.txt"
-var fileNCStarget = "/Users/fhalsius/Documents/Code/go/mycolorpicker/io/target/ncs.json"
+// clean.go holds the cleaners that parse the source files in db/source into catalog records.
+// The cleaners are run by Build from the manifest, see build.go.
+// Every malformed row is reported on the catalog report instead of being skipped silently.
 
 // Target structure for JSON data.
 type ColorData struct {
@@ -34,18 +25,27 @@ type ColorData struct {
 		B float64 `json:"b"`
 	} `json:"lab"`
 	Code *string `json:"code,omitempty"`
-	Hex *string  `json:"hex,omitempty"`
-	Src *string  `json:"src,omitempty"`
+	Hex  *string `json:"hex,omitempty"`
+	Src  *string `json:"src,omitempty"`
+
+	// Optional metadata, see types.Meta
+	License *string  `json:"license,omitempty"`
+	Aliases []string `json:"aliases,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+
+	Line int `json:"-"` // source line, for the build report
 }
 
 // Source structure for RAL CSV data.
 type RALColorData struct {
 	Name      string  `json:"name"`
+	Title     string  `json:"title"` // common name, e.g. "Ink Black"
 	Hue       string  `json:"hue"`
 	Lightness string  `json:"lightness"`
 	Chroma    string  `json:"chroma"`
 	RGB       [3]int  `json:"rgb"`
 	Code      *string `json:"code,omitempty"`
+	Line      int     `json:"-"` // source line
 }
 
 type NCSColorData struct {
@@ -53,124 +53,144 @@ type NCSColorData struct {
 	Hex  string `json:"hex"`
 }
 
-func CleanNAME() {
+// Cleaner parses a source file into catalog records and reports bad rows on r
+type Cleaner func(source string, r *CatalogReport) []ColorData
+
+// Cleaners maps the manifest kind of a catalog to its cleaner
+var Cleaners = map[string]Cleaner{
+	"names":   CleanNAME,
+	"ral":     CleanRAL,
+	"pantone": CleanPAN,
+	"ncs":     CleanNCS,
+}
+
+var hexPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
 
-	csvFile, err := os.Open(fileNAME)
+// CleanNAME parses a color name CSV with a header row: name,hex[,good name]
+func CleanNAME(source string, r *CatalogReport) []ColorData {
+	csvFile, err := os.Open(source)
 	if err != nil {
-		fmt.Println("Error opening CSV file:", err)
-		return
+		r.Errorf(0, "error opening CSV file: %v", err)
+		return nil
 	}
 	defer csvFile.Close()
 
 	reader := csv.NewReader(csvFile)
+	reader.FieldsPerRecord = -1 // column count is validated per row
 
 	var colorDataSlice []ColorData
 
-	for {
-
-		line, err := reader.Read()
-		if err != nil {
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
 			break // End of file.
 		}
+		if err != nil {
+			r.Errorf(line, "error reading CSV row: %v", err)
+			continue
+		}
+		if line == 1 {
+			continue // skip header
+		}
+		r.Rows++
+
+		if len(row) < 2 || len(row) > 3 {
+			r.Errorf(line, "expected 2 or 3 columns, got %d", len(row))
+			continue
+		}
 
 		// Parse
-		name := line[0]
-		hexValue := line[1]
+		name := strings.TrimSpace(row[0])
+		hexValue := strings.ToLower(strings.TrimSpace(row[1]))
 
-		// Hex to Lab
-		color, _ := colorful.Hex(hexValue)
-		l, a, b := color.Lab()
-
-		ref := "community"
-
-		// Store
-		colorData := ColorData{
-			Name: name,
-			Lab: struct {
-				L float64 `json:"L"`
-				A float64 `json:"a"`
-				B float64 `json:"b"`
-			}{
-				L: l,
-				A: a,
-				B: b,
-			},
-			Hex: &hexValue,
-			Src: &ref,
+		if name == "" {
+			r.Errorf(line, "empty name")
+			continue
 		}
 
-		// Append the ColorData to the slice.
-		colorDataSlice = append(colorDataSlice, colorData)
-	}
+		// Hex to Lab
+		color, ok := parseHex(hexValue, line, r)
+		if !ok {
+			continue
+		}
 
-	// Convert color data slice to JSON.
-	jsonData, err := json.Marshal(colorDataSlice)
-	if err != nil {
-		fmt.Println("Error marshaling JSON:", err)
-		return
-	}
+		colorData := newColorData(name, color, line)
 
-	// Write JSON data to a JSON file.
-	jsonFile, err := os.Create(fileNAMAtarget)
-	if err != nil {
-		fmt.Println("Error creating JSON file:", err)
-		return
-	}
-	defer jsonFile.Close()
+		// Names marked in the "good name" column are tagged as preferred
+		if len(row) == 3 && strings.TrimSpace(row[2]) != "" {
+			colorData.Tags = append(colorData.Tags, "good")
+		}
 
-	_, err = jsonFile.Write(jsonData)
-	if err != nil {
-		fmt.Println("Error writing JSON data to file:", err)
-		return
+		// Append the ColorData to the slice.
+		colorDataSlice = append(colorDataSlice, colorData)
 	}
 
-	fmt.Println("Conversion completed. JSON file created.")
+	return colorDataSlice
 }
 
-func CleanRAL() {
+// CleanRAL parses the RAL PLUS CSV: Name;H;L;C;R;G;B;Code
+func CleanRAL(source string, r *CatalogReport) []ColorData {
 	// Open the CSV file for reading.
-	csvFile, err := os.Open(fileRAL)
+	csvFile, err := os.Open(source)
 	if err != nil {
-		fmt.Println("Error opening CSV file:", err)
-		return
+		r.Errorf(0, "error opening CSV file: %v", err)
+		return nil
 	}
 	defer csvFile.Close()
 
 	// Create a CSV reader.
 	reader := csv.NewReader(csvFile)
 	reader.Comma = ';'
+	reader.FieldsPerRecord = -1 // column count is validated per row
 
 	// Initialize a slice to store RAL color data.
 	var ralData []RALColorData
 
-	for {
+	for line := 1; ; line++ {
 		// Read a line from the CSV.
 		row, err := reader.Read()
+		if err == io.EOF {
+			// End of file reached.
+			break
+		}
 		if err != nil {
-			if err == io.EOF {
-				// End of file reached.
-				break
-			}
-			fmt.Println("Error reading CSV row:", err)
-			return
+			r.Errorf(line, "error reading CSV row: %v", err)
+			continue
+		}
+		if line == 1 {
+			continue // skip header
+		}
+		r.Rows++
+
+		if len(row) != 8 {
+			r.Errorf(line, "expected 8 columns, got %d", len(row))
+			continue
 		}
 
 		// Extract RAL color data from the CSV.
+		title := row[0]
 		hue := row[1]
 		lightness := row[2]
 		chroma := row[3]
-		r, _ := strconv.Atoi(row[4])
-		g, _ := strconv.Atoi(row[5])
-		b, _ := strconv.Atoi(row[6])
-		code := row[7]
+		rgb, ok := parseRGB(row[4:7], line, r)
+		if !ok {
+			continue
+		}
+		code := strings.TrimSpace(row[7])
+		if code == "" {
+			r.Errorf(line, "empty RAL code")
+			continue
+		}
 
 		// Create a RALColorData structure.
 		colorData := RALColorData{
 			Name:      code,
+			Title:     title,
 			Hue:       hue,
 			Lightness: lightness,
 			Chroma:    chroma,
-			RGB:       [3]int{r, g, b},
+			RGB:       rgb,
+			Line:      line,
 		}
 
 		// Append the RALColorData to the slice.
@@ -178,56 +198,15 @@ func CleanRAL() {
 	}
 
 	// Convert RGB to LAB.
-	labData := ralRGBtoLab(ralData)
-
-	// Convert labData to JSON.
-	jsonData, err := json.Marshal(labData)
-	if err != nil {
-		fmt.Println("Error marshaling JSON:", err)
-		return
-	}
-
-	// Write JSON data to a JSON file.
-	jsonFile, err := os.Create(fileRALtarget)
-	if err != nil {
-		fmt.Println("Error creating JSON file:", err)
-		return
-	}
-	defer jsonFile.Close()
-
-	_, err = jsonFile.Write(jsonData)
-	if err != nil {
-		fmt.Println("Error writing JSON data to file:", err)
-		return
-	}
-
-	fmt.Println("Conversion completed. JSON file created: ral_colors.json")
+	return ralRGBtoLab(ralData)
 }
 
 func ralRGBtoLab(ralData []RALColorData) []ColorData {
 	var labData []ColorData
 
 	for _, entry := range ralData {
-		rgb := colorful.Color{
-			R: float64(entry.RGB[0]) / 255.0,
-			G: float64(entry.RGB[1]) / 255.0,
-			B: float64(entry.RGB[2]) / 255.0,
-		}
-
-		l, a, b := rgb.Lab()
-
-		colorData := ColorData{
-			Name: entry.Name,
-			Lab: struct {
-				L float64 `json:"L"`
-				A float64 `json:"a"`
-				B float64 `json:"b"`
-			}{
-				L: l,
-				A: a,
-				B: b,
-			},
-		}
+		colorData := newColorData(entry.Name, rgbColor(entry.RGB), entry.Line)
+		colorData.Aliases = []string{entry.Title}
 
 		labData = append(labData, colorData)
 	}
@@ -235,12 +214,14 @@ func ralRGBtoLab(ralData []RALColorData) []ColorData {
 	return labData
 }
 
-func CleanPAN() {
+// CleanPAN parses a Pantone source file with lines "R G B CODE".
+// TCX/TPG codes contain spaces (e.g. "11-0601 TCX"), so the code is the rest of the line.
+func CleanPAN(source string, r *CatalogReport) []ColorData {
 	// Open the source text file for reading.
-	file, err := os.Open(filePAN)
+	file, err := os.Open(source)
 	if err != nil {
-		fmt.Println("Error opening source file:", err)
-		return
+		r.Errorf(0, "error opening source file: %v", err)
+		return nil
 	}
 	defer file.Close()
 
@@ -251,71 +232,45 @@ func CleanPAN() {
 	scanner := bufio.NewScanner(file)
 
 	// Iterate through each line in the file.
-	for scanner.Scan() {
-		line := scanner.Text()
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		r.Rows++
 
 		// Split the line by space to extract RGB values and Pantone code.
-		parts := strings.Fields(line)
-		if len(parts) != 4 {
-			fmt.Println("Error parsing line:", line)
+		parts := strings.Fields(text)
+		if len(parts) < 4 {
+			r.Errorf(line, "expected at least 4 columns, got %d", len(parts))
 			continue
 		}
 
 		// Extract RGB values and Pantone code.
-		r, _ := strconv.Atoi(parts[0])
-		g, _ := strconv.Atoi(parts[1])
-		b, _ := strconv.Atoi(parts[2])
-		pantoneCode := parts[3]
-
-		// Calculate LAB values from RGB.
-
-		c := colorful.Color{float64(r), float64(g), float64(b)}
-		rr, gg, bb := c.Lab()
-
-		// Create a ColorData struct and add it to the slice.
-		colorData := ColorData{
-			Name: pantoneCode,
-			Lab: struct {
-				L float64 `json:"L"`
-				A float64 `json:"a"`
-				B float64 `json:"b"`
-			}{
-				L: rr,
-				A: gg,
-				B: bb,
-			},
+		rgb, ok := parseRGB(parts[0:3], line, r)
+		if !ok {
+			continue
 		}
-		colorDataSlice = append(colorDataSlice, colorData)
+		pantoneCode := strings.Join(parts[3:], " ")
+
+		colorDataSlice = append(colorDataSlice, newColorData(pantoneCode, rgbColor(rgb), line))
 	}
 
 	// Check for scanner errors.
 	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading source file:", err)
-		return
+		r.Errorf(0, "error reading source file: %v", err)
 	}
 
-	// Serialize the color data to JSON.
-	colorDataJSON, err := json.Marshal(colorDataSlice)
-	if err != nil {
-		fmt.Println("Error marshaling color data to JSON:", err)
-		return
-	}
-
-	// Write the JSON data to a file.
-	if err := os.WriteFile(filePANtarget, colorDataJSON, os.ModePerm); err != nil {
-		fmt.Println("Error writing JSON data to file:", err)
-		return
-	}
-
-	fmt.Println("Color data converted and saved")
+	return colorDataSlice
 }
 
-func CleanNCS() {
+// CleanNCS parses the NCS SCSS variables: $NCS_0300-N:#f7f7f7;
+func CleanNCS(source string, r *CatalogReport) []ColorData {
 	// Open the source text file for reading.
-	file, err := os.Open(fileNCS)
+	file, err := os.Open(source)
 	if err != nil {
-		fmt.Println("Error opening source file:", err)
-		return
+		r.Errorf(0, "error opening source file: %v", err)
+		return nil
 	}
 	defer file.Close()
 
@@ -326,70 +281,90 @@ func CleanNCS() {
 	scanner := bufio.NewScanner(file)
 
 	// Iterate through each line in the file.
-	for scanner.Scan() {
-		line := scanner.Text()
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
 
-		line = strings.TrimPrefix(line, "$")
-		line = strings.TrimSpace(line)
+		text = strings.TrimPrefix(text, "$")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		r.Rows++
 
-		// Split the line by space to extract RGB values and Pantone code.
-		parts := strings.Split(line, ":")
+		// Split the line by colon to extract the NCS code and hex value.
+		parts := strings.Split(text, ":")
 		if len(parts) != 2 {
-			fmt.Println("Error parsing line:", line)
+			r.Errorf(line, "expected 2 columns, got %d", len(parts))
 			continue
 		}
 
-		// Extract RGB values and Pantone code.
-		name := parts[0]
-		hex := parts[1]
-
-		// Calculate LAB values from RGB.
-
-		c, err := colorful.Hex(hex)
+		name := strings.TrimSpace(parts[0])
+		hex := strings.ToLower(strings.TrimSpace(strings.TrimSuffix(parts[1], ";")))
 
-		if err != nil {
-			fmt.Println("Error parsing hex value:", hex)
-			break
+		// Calculate LAB values from hex.
+		c, ok := parseHex(hex, line, r)
+		if !ok {
+			continue
 		}
 
-		rr, gg, bb := c.Lab()
-
-		// Create a ColorData struct and add it to the slice.
-		colorData := ColorData{
-			Name: name,
-			Lab: struct {
-				L float64 `json:"L"`
-				A float64 `json:"a"`
-				B float64 `json:"b"`
-			}{
-				L: rr,
-				A: gg,
-				B: bb,
-			},
-		}
-		colorDataSlice = append(colorDataSlice, colorData)
+		colorDataSlice = append(colorDataSlice, newColorData(name, c, line))
 	}
 
 	// Check for scanner errors.
 	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading source file:", err)
-		return
+		r.Errorf(0, "error reading source file: %v", err)
 	}
 
-	// Serialize the color data to JSON.
-	colorDataJSON, err := json.Marshal(colorDataSlice)
+	return colorDataSlice
+}
+
+// *** HELPER FUNCTIONS ***
+
+// newColorData creates a record with the Lab coordinates and hex of c, read at a source line
+func newColorData(name string, c colorful.Color, line int) ColorData {
+	l, a, b := c.Lab()
+	hex := c.Hex()
+
+	colorData := ColorData{Name: name, Hex: &hex, Line: line}
+	colorData.Lab.L = l
+	colorData.Lab.A = a
+	colorData.Lab.B = b
+	return colorData
+}
+
+// parseHex validates a "#rrggbb" value, bad values are reported as errors
+func parseHex(hex string, line int, r *CatalogReport) (colorful.Color, bool) {
+	if !hexPattern.MatchString(hex) {
+		r.Errorf(line, "bad hex value %q", hex)
+		return colorful.Color{}, false
+	}
+	c, err := colorful.Hex(hex)
 	if err != nil {
-		fmt.Println("Error marshaling color data to JSON:", err)
-		return
+		r.Errorf(line, "bad hex value %q: %v", hex, err)
+		return colorful.Color{}, false
 	}
+	return c, true
+}
 
-	// Write the JSON data to a file.
-	if err := os.WriteFile(fileNCStarget, colorDataJSON, os.ModePerm); err != nil {
-		fmt.Println("Error writing JSON data to file:", err)
-		return
+// parseRGB validates three 0-255 integer columns, bad values are reported as errors
+func parseRGB(cols []string, line int, r *CatalogReport) ([3]int, bool) {
+	var rgb [3]int
+	for i, col := range cols {
+		v, err := strconv.Atoi(strings.TrimSpace(col))
+		if err != nil || v < 0 || v > 255 {
+			r.Errorf(line, "bad RGB value %q", col)
+			return rgb, false
+		}
+		rgb[i] = v
 	}
-
-	fmt.Println("Color data converted and saved")
+	return rgb, true
 }
 
-
+// rgbColor converts 0-255 RGB values to a colorful.Color, which expects 0-1
+func rgbColor(rgb [3]int) colorful.Color {
+	return colorful.Color{
+		R: float64(rgb[0]) / 255.0,
+		G: float64(rgb[1]) / 255.0,
+		B: float64(rgb[2]) / 255.0,
+	}
+}
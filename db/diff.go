@@ -0,0 +1,181 @@
+package io
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// diff.go compares a freshly built catalog with the previous target JSON (drift report).
+// It lists added, removed and renamed entries, and entries whose Lab moved by more than a ΔE threshold.
+// ΔE is CIEDE2000 on the usual 0-100 L scale, 1.0 is about a just noticeable difference.
+
+// Shift is an entry whose color moved between two builds
+type Shift struct {
+	Name   string  `json:"name"`
+	DeltaE float64 `json:"deltaE"`
+	Old    string  `json:"old"` // hex of the previous color
+	New    string  `json:"new"` // hex of the new color
+}
+
+// Rename is an entry that kept its color but changed its name
+type Rename struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// DiffReport is the drift report of one catalog
+type DiffReport struct {
+	Previous  bool     `json:"previous"` // false when there was no previous target to compare with
+	Threshold float64  `json:"threshold"`
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Renamed   []Rename `json:"renamed"`
+	Shifted   []Shift  `json:"shifted"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// LoadTarget reads the records of a target JSON file
+func LoadTarget(path string) ([]ColorData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []ColorData
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parse target %s: %w", path, err)
+	}
+	return records, nil
+}
+
+// Diff compares the previous records of a catalog with the new ones.
+// Entries are matched by name, an entry removed and added with the same color is a rename.
+func Diff(previous []ColorData, next []ColorData, threshold float64) *DiffReport {
+	d := &DiffReport{
+		Previous:  true,
+		Threshold: threshold,
+		Added:     []string{},
+		Removed:   []string{},
+		Renamed:   []Rename{},
+		Shifted:   []Shift{},
+	}
+
+	old := make(map[string]ColorData, len(previous))
+	for _, c := range previous {
+		old[c.Name] = c
+	}
+	seen := make(map[string]bool, len(next))
+
+	var added []ColorData
+	for _, c := range next {
+		seen[c.Name] = true
+
+		prev, ok := old[c.Name]
+		if !ok {
+			added = append(added, c)
+			continue
+		}
+
+		deltaE := deltaE(prev, c)
+		if deltaE > threshold {
+			d.Shifted = append(d.Shifted, Shift{c.Name, deltaE, labHex(prev), labHex(c)})
+		} else {
+			d.Unchanged++
+		}
+	}
+
+	// Removed entries by color, to detect renames
+	removed := make(map[string][]string)
+	for _, c := range previous {
+		if !seen[c.Name] {
+			hex := labHex(c)
+			removed[hex] = append(removed[hex], c.Name)
+		}
+	}
+
+	for _, c := range added {
+		hex := labHex(c)
+		if names := removed[hex]; len(names) > 0 {
+			d.Renamed = append(d.Renamed, Rename{names[0], c.Name})
+			removed[hex] = names[1:]
+			continue
+		}
+		d.Added = append(d.Added, c.Name)
+	}
+
+	for _, names := range removed {
+		d.Removed = append(d.Removed, names...)
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Slice(d.Renamed, func(i, j int) bool { return d.Renamed[i].Old < d.Renamed[j].Old })
+	sort.Slice(d.Shifted, func(i, j int) bool { return d.Shifted[i].DeltaE > d.Shifted[j].DeltaE })
+
+	return d
+}
+
+// Changed reports whether the catalog drifted
+func (d *DiffReport) Changed() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Renamed)+len(d.Shifted) > 0
+}
+
+// Print writes the drift report of a catalog to w, listing at most limit entries per section
+func (d *DiffReport) Print(w io.Writer, catalog string, limit int) {
+	if !d.Previous {
+		fmt.Fprintf(w, "%s: no previous target, all entries are new\n", catalog)
+		return
+	}
+
+	fmt.Fprintf(w, "%s: %d added, %d removed, %d renamed, %d shifted (ΔE > %.2f), %d unchanged\n",
+		catalog, len(d.Added), len(d.Removed), len(d.Renamed), len(d.Shifted), d.Threshold, d.Unchanged)
+
+	for i, name := range d.Added {
+		if i == limit {
+			fmt.Fprintf(w, "  ... %d more added\n", len(d.Added)-limit)
+			break
+		}
+		fmt.Fprintf(w, "  + %s\n", name)
+	}
+	for i, name := range d.Removed {
+		if i == limit {
+			fmt.Fprintf(w, "  ... %d more removed\n", len(d.Removed)-limit)
+			break
+		}
+		fmt.Fprintf(w, "  - %s\n", name)
+	}
+	for i, r := range d.Renamed {
+		if i == limit {
+			fmt.Fprintf(w, "  ... %d more renamed\n", len(d.Renamed)-limit)
+			break
+		}
+		fmt.Fprintf(w, "  ~ %s -> %s\n", r.Old, r.New)
+	}
+	for i, s := range d.Shifted {
+		if i == limit {
+			fmt.Fprintf(w, "  ... %d more shifted\n", len(d.Shifted)-limit)
+			break
+		}
+		fmt.Fprintf(w, "  Δ %s %s -> %s (ΔE %.2f)\n", s.Name, s.Old, s.New, s.DeltaE)
+	}
+}
+
+// *** HELPER FUNCTIONS ***
+
+// deltaE is the CIEDE2000 difference of two records on the 0-100 L scale
+func deltaE(c1 ColorData, c2 ColorData) float64 {
+	return labColor(c1).DistanceCIEDE2000(labColor(c2)) * 100
+}
+
+func labColor(c ColorData) colorful.Color {
+	return colorful.Lab(c.Lab.L, c.Lab.A, c.Lab.B)
+}
+
+// labHex derives the hex from the Lab coordinates, older targets have no hex field
+func labHex(c ColorData) string {
+	return labColor(c).Clamped().Hex()
+}
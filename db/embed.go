@@ -0,0 +1,57 @@
+package io
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// embed.go bundles the default catalogs into the binary, so the server runs without any data files.
+// A data directory with the same layout as db/ (target/..., source/...) overrides single files:
+// a file found there is used, every other file is read from the embedded copy.
+
+//go:embed manifest.json target/*.json target/*.kdt
+var Embedded embed.FS
+
+// manifestFile is the build manifest, the server reads the catalog list from it
+const manifestFile = "manifest.json"
+
+// ReadManifest reads the manifest of the catalogs, from dataDir if it has one, see ReadData
+func ReadManifest(dataDir string) (*Manifest, error) {
+	data, from, err := ReadData(dataDir, manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(data, from)
+}
+
+// ReadData reads a data file such as "target/ncs.json", from dataDir if it has the file,
+// otherwise from the embedded catalogs. It returns the file and where it was read from.
+func ReadData(dataDir string, name string) ([]byte, string, error) {
+	if dataDir != "" {
+		path := filepath.Join(dataDir, filepath.FromSlash(name))
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return data, path, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, path, err
+		}
+	}
+
+	data, err := Embedded.ReadFile(name)
+	return data, "embedded:" + name, err
+}
+
+// HasData reports whether a data file exists in dataDir or in the embedded catalogs
+func HasData(dataDir string, name string) bool {
+	if dataDir != "" {
+		if _, err := os.Stat(filepath.Join(dataDir, filepath.FromSlash(name))); err == nil {
+			return true
+		}
+	}
+	_, err := fs.Stat(Embedded, name)
+	return err == nil
+}
@@ -0,0 +1,304 @@
+package io
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"sort"
+	"strings"
+
+	ty "github.com/codcodea/cc/types"
+	"github.com/kyroy/kdtree"
+)
+
+// snapshot.go holds the compact binary snapshot of a catalog KD tree (.kdt next to the target JSON).
+// Parsing the indented JSON and inserting point by point is slow and gives an unbalanced tree,
+// so build-db also writes the points in the pre-order of a balanced tree.
+// Inserting the points in that order rebuilds the same balanced tree without any sorting.
+// The snapshot records the checksum of the JSON it was built from, a stale snapshot is not used.
+
+// Layout, little endian:
+//
+//	magic   [4]byte "CCKD"
+//	version uint16
+//	flags   uint16  (reserved, 0)
+//	count   uint32
+//	source  uint32  CRC-32 (IEEE) of the target JSON the snapshot was built from
+//	points  count x { L, a, b float64; name string; meta }
+//	crc     uint32  CRC-32 (IEEE) of everything before it
+//
+// Strings are a uvarint length followed by the bytes. meta is a presence byte, and if 1:
+// code, hex, src, license string; aliases and tags as a uvarint count of strings.
+
+const (
+	SnapshotExt     = ".kdt"
+	SnapshotVersion = 1
+)
+
+var snapshotMagic = [4]byte{'C', 'C', 'K', 'D'}
+
+var (
+	ErrSnapshotFormat   = errors.New("snapshot: not a KD tree snapshot")
+	ErrSnapshotVersion  = errors.New("snapshot: unsupported version")
+	ErrSnapshotChecksum = errors.New("snapshot: checksum mismatch")
+	ErrSnapshotStale    = errors.New("snapshot: built from a different JSON")
+)
+
+// SnapshotPath returns the snapshot path of a target JSON file, e.g. target/ncs.json -> target/ncs.kdt
+func SnapshotPath(target string) string {
+	return strings.TrimSuffix(target, ".json") + SnapshotExt
+}
+
+// EncodeSnapshot encodes the points as a balanced KD tree snapshot of the JSON source
+func EncodeSnapshot(points []ty.CustomPoint, source []byte) []byte {
+	ordered := balancedOrder(points)
+
+	var buf bytes.Buffer
+	buf.Write(snapshotMagic[:])
+	binary.Write(&buf, binary.LittleEndian, uint16(SnapshotVersion))
+	binary.Write(&buf, binary.LittleEndian, uint16(0))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(ordered)))
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(source))
+
+	for _, p := range ordered {
+		for _, v := range p.Lab.LAB {
+			binary.Write(&buf, binary.LittleEndian, math.Float64bits(v))
+		}
+		writeString(&buf, p.Name)
+
+		if p.Meta == nil {
+			buf.WriteByte(0)
+			continue
+		}
+		buf.WriteByte(1)
+		writeString(&buf, p.Meta.Code)
+		writeString(&buf, p.Meta.Hex)
+		writeString(&buf, p.Meta.Src)
+		writeString(&buf, p.Meta.License)
+		writeStrings(&buf, p.Meta.Aliases)
+		writeStrings(&buf, p.Meta.Tags)
+	}
+
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes()
+}
+
+// DecodeSnapshot decodes a snapshot into its points, in the pre-order of the balanced tree.
+// If source is not nil it must be the JSON the snapshot was built from.
+func DecodeSnapshot(data []byte, source []byte) ([]ty.CustomPoint, error) {
+	if len(data) < 20 || !bytes.Equal(data[:4], snapshotMagic[:]) {
+		return nil, ErrSnapshotFormat
+	}
+	if v := binary.LittleEndian.Uint16(data[4:6]); v != SnapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, v)
+	}
+
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, ErrSnapshotChecksum
+	}
+
+	if source != nil && binary.LittleEndian.Uint32(body[12:16]) != crc32.ChecksumIEEE(source) {
+		return nil, ErrSnapshotStale
+	}
+
+	// A point is at least 26 bytes: Lab, an empty name and the meta byte
+	count := int(binary.LittleEndian.Uint32(body[8:12]))
+	if count > (len(body)-16)/26 {
+		return nil, ErrSnapshotFormat
+	}
+
+	// All strings are sliced from one copy of the body, one allocation instead of one per string
+	r := &snapshotReader{raw: body, data: string(body), pos: 16}
+	points := make([]ty.CustomPoint, count)
+
+	for i := range points {
+		var lab [3]float64
+		for j := range lab {
+			lab[j] = math.Float64frombits(r.uint64())
+		}
+		points[i] = ty.NewPoint(r.string(), lab)
+
+		if r.byte() == 1 {
+			points[i].Meta = &ty.Meta{
+				Code:    r.string(),
+				Hex:     r.string(),
+				Src:     r.string(),
+				License: r.string(),
+				Aliases: r.strings(),
+				Tags:    r.strings(),
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+
+	if r.pos != len(r.data) {
+		return nil, ErrSnapshotFormat
+	}
+	return points, nil
+}
+
+// SnapshotTree decodes a snapshot and rebuilds its balanced KD tree, see DecodeSnapshot
+func SnapshotTree(data []byte, source []byte) (*kdtree.KDTree, int, error) {
+	points, err := DecodeSnapshot(data, source)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Inserting in pre-order reproduces the balanced tree
+	tree := kdtree.New(nil)
+	for _, p := range points {
+		tree.Insert(p)
+	}
+	return tree, len(points), nil
+}
+
+// Point converts a catalog record to a KD tree point
+func (c ColorData) Point() ty.CustomPoint {
+	record := ty.JSONRecord{Name: c.Name}
+	record.Lab.L = c.Lab.L
+	record.Lab.A = c.Lab.A
+	record.Lab.B = c.Lab.B
+	record.Aliases = c.Aliases
+	record.Tags = c.Tags
+
+	if c.Code != nil {
+		record.Code = *c.Code
+	}
+	if c.Hex != nil {
+		record.Hex = *c.Hex
+	}
+	if c.Src != nil {
+		record.Src = *c.Src
+	}
+	if c.License != nil {
+		record.License = *c.License
+	}
+	return ty.NewRecordPoint(record)
+}
+
+// *** HELPER FUNCTIONS ***
+
+// balancedOrder returns the points in the pre-order of a balanced KD tree.
+// The median is moved left past equal values, so the left subtree is strictly smaller
+// on the split axis, the same rule kdtree.Insert uses to place a point.
+func balancedOrder(points []ty.CustomPoint) []ty.CustomPoint {
+	sorted := make([]ty.CustomPoint, len(points))
+	copy(sorted, points)
+
+	ordered := make([]ty.CustomPoint, 0, len(points))
+
+	var split func(p []ty.CustomPoint, axis int)
+	split = func(p []ty.CustomPoint, axis int) {
+		if len(p) == 0 {
+			return
+		}
+		sort.SliceStable(p, func(i, j int) bool { return p[i].Dimension(axis) < p[j].Dimension(axis) })
+
+		mid := len(p) / 2
+		for mid > 0 && p[mid-1].Dimension(axis) == p[mid].Dimension(axis) {
+			mid--
+		}
+
+		ordered = append(ordered, p[mid])
+		next := (axis + 1) % 3
+		split(p[:mid], next)
+		split(p[mid+1:], next)
+	}
+	split(sorted, 0)
+
+	return ordered
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	var n [binary.MaxVarintLen64]byte
+	buf.Write(n[:binary.PutUvarint(n[:], uint64(len(s)))])
+	buf.WriteString(s)
+}
+
+func writeStrings(buf *bytes.Buffer, list []string) {
+	var n [binary.MaxVarintLen64]byte
+	buf.Write(n[:binary.PutUvarint(n[:], uint64(len(list)))])
+	for _, s := range list {
+		writeString(buf, s)
+	}
+}
+
+// snapshotReader reads the point records, the first error sticks
+type snapshotReader struct {
+	raw  []byte
+	data string // raw as a string, to slice names from
+	pos  int
+	err  error
+}
+
+func (r *snapshotReader) fail() {
+	if r.err == nil {
+		r.err = ErrSnapshotFormat
+	}
+	r.pos = len(r.data)
+}
+
+func (r *snapshotReader) byte() byte {
+	if r.pos+1 > len(r.data) {
+		r.fail()
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *snapshotReader) uint64() uint64 {
+	if r.pos+8 > len(r.data) {
+		r.fail()
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(r.raw[r.pos : r.pos+8])
+	r.pos += 8
+	return v
+}
+
+func (r *snapshotReader) uvarint() int {
+	var v uint64
+	for shift := 0; shift < 64; shift += 7 {
+		b := r.byte()
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			break
+		}
+	}
+	if v > uint64(len(r.data)) {
+		r.fail()
+		return 0
+	}
+	return int(v)
+}
+
+func (r *snapshotReader) string() string {
+	n := r.uvarint()
+	if r.pos+n > len(r.data) {
+		r.fail()
+		return ""
+	}
+	s := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return s
+}
+
+func (r *snapshotReader) strings() []string {
+	n := r.uvarint()
+	if n == 0 {
+		return nil
+	}
+	list := make([]string, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		list = append(list, r.string())
+	}
+	return list
+}
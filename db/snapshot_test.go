@@ -0,0 +1,139 @@
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+
+	ty "github.com/codcodea/cc/types"
+	"github.com/kyroy/kdtree"
+)
+
+// testPoints returns the points of an embedded catalog and its JSON
+func testPoints(t *testing.T, target string) ([]ty.CustomPoint, []byte) {
+	t.Helper()
+	data, _, err := ReadData("", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []ColorData
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatal(err)
+	}
+	points := make([]ty.CustomPoint, len(records))
+	for i, c := range records {
+		points[i] = c.Point()
+	}
+	return points, data
+}
+
+func sortPoints(points []ty.CustomPoint) {
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].Name != points[j].Name {
+			return points[i].Name < points[j].Name
+		}
+		return points[i].Lab.LAB[0] < points[j].Lab.LAB[0]
+	})
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, target := range []string{"target/ncs.json", "target/RAL_PLUS_CIELAB1931_sRGB.json"} {
+		points, source := testPoints(t, target)
+
+		decoded, err := DecodeSnapshot(EncodeSnapshot(points, source), source)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+
+		want := append([]ty.CustomPoint(nil), points...)
+		sortPoints(want)
+		sortPoints(decoded)
+		if !reflect.DeepEqual(decoded, want) {
+			t.Errorf("%s: decoded points differ from the encoded ones", target)
+		}
+	}
+}
+
+func TestSnapshotTreeNearest(t *testing.T) {
+	points, source := testPoints(t, "target/ncs.json")
+
+	tree, n, err := SnapshotTree(EncodeSnapshot(points, source), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(points) {
+		t.Fatalf("snapshot has %d points, want %d", n, len(points))
+	}
+
+	kd := make([]kdtree.Point, len(points))
+	for i, p := range points {
+		kd[i] = p
+	}
+	want := kdtree.New(kd)
+
+	// A grid over the Lab space, in the 0-1 scale of colorful
+	for l := 0.0; l <= 1; l += 0.1 {
+		for a := -0.5; a <= 0.5; a += 0.1 {
+			for b := -0.5; b <= 0.5; b += 0.1 {
+				q := ty.NewPoint("", [3]float64{l, a, b})
+				got, exp := tree.KNN(q, 5), want.KNN(q, 5)
+				if len(got) != len(exp) {
+					t.Fatalf("KNN(%v): %d points, want %d", q.Lab.LAB, len(got), len(exp))
+				}
+				// Equidistant points may come in either order, the distances must match
+				for i := range got {
+					if dg, de := distance(q, got[i]), distance(q, exp[i]); math.Abs(dg-de) > 1e-12 {
+						t.Fatalf("KNN(%v)[%d]: %s at %g, want %s at %g", q.Lab.LAB, i,
+							got[i].(ty.CustomPoint).Name, dg, exp[i].(ty.CustomPoint).Name, de)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	points, source := testPoints(t, "target/ncs.json")
+	data := EncodeSnapshot(points, source)
+
+	corrupt := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), data...))
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		source []byte
+		err    error
+	}{
+		{"crc", corrupt(func(b []byte) []byte { b[len(b)-1] ^= 0xff; return b }), source, ErrSnapshotChecksum},
+		{"body", corrupt(func(b []byte) []byte { b[40] ^= 0xff; return b }), source, ErrSnapshotChecksum},
+		{"truncated", data[:len(data)/2], source, ErrSnapshotChecksum},
+		{"magic", corrupt(func(b []byte) []byte { b[0] = 'X'; return b }), source, ErrSnapshotFormat},
+		{"empty", nil, source, ErrSnapshotFormat},
+		{"version", corrupt(func(b []byte) []byte { b[4] = 9; return b }), source, ErrSnapshotVersion},
+		{"stale", data, []byte("[]"), ErrSnapshotStale},
+	}
+	for _, tt := range tests {
+		if _, err := DecodeSnapshot(tt.data, tt.source); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// Without a source the staleness is not checked
+	if _, err := DecodeSnapshot(data, nil); err != nil {
+		t.Errorf("no source: %v", err)
+	}
+}
+
+func distance(a, b kdtree.Point) float64 {
+	var sum float64
+	for i := 0; i < a.Dimensions(); i++ {
+		d := a.Dimension(i) - b.Dimension(i)
+		sum += d * d
+	}
+	return sum
+}
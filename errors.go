@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// errors.go holds the JSON error envelope of the API.
+// Every failing request, HTTP or websocket, is answered with the same error object:
+//
+//	{"error":{"code":"invalid_color","message":"color must be 6 hex digits","input":"ff00","hint":"e.g. /colors/ff0000"}}
+//
+// The code tells invalid input (4xx) from server faults (5xx), clients should switch on it, not on the message.
+
+// Error codes
+const (
+	ErrInvalidColor    = "invalid_color"   // the color is not 6 hex digits
+	ErrInvalidParam    = "invalid_param"   // a query parameter is malformed or out of range
+	ErrInvalidQuery    = "invalid_query"   // the name query does not parse, see query.go
+	ErrInvalidMessage  = "invalid_message" // a websocket message is malformed or of an unknown type
+	ErrNotFound        = "not_found"       // no such route or search
+	ErrMethod          = "method_not_allowed"
+	ErrTooLarge        = "too_large"          // the request body exceeds -max-body
+	ErrInvalidAPIKey   = "invalid_api_key"    // the API key is unknown, or missing with -require-api-key
+	ErrOriginDenied    = "origin_not_allowed" // the Origin is not allowed for the API key
+	ErrRateLimited     = "rate_limited"       // the client ran out of tokens, see Retry-After and ratelimit.go
+	ErrInternal        = "internal_error"     // a server fault, the request may be retried
+	ErrNotReady        = "not_ready"          // the catalogs are loading or the server is shutting down, see health.go
+	ErrTooManySessions = "too_many_sessions"  // all websocket sessions are in use, see socket.go
+)
+
+// APIError is the error object of the envelope
+type APIError struct {
+	Status  int    `json:"-"` // HTTP status
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Input   string `json:"input,omitempty"` // the offending input
+	Hint    string `json:"hint,omitempty"`  // how to fix the request
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// ErrorEnvelope is the body of an HTTP error response
+type ErrorEnvelope struct {
+	Error *APIError `json:"error"`
+}
+
+// NewError creates an error of the envelope, handlers return it to echo
+func NewError(status int, code string, message string, input string, hint string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message, Input: input, Hint: hint}
+}
+
+// InvalidColor reports a color that is not 6 hex digits
+func InvalidColor(input string) *APIError {
+	return NewError(http.StatusBadRequest, ErrInvalidColor, "color must be 6 hex digits", input,
+		"send the hex with or without '#', e.g. ff0000")
+}
+
+// InvalidParam reports a malformed query parameter
+func InvalidParam(name string, input string, err error, hint string) *APIError {
+	return NewError(http.StatusBadRequest, ErrInvalidParam, fmt.Sprintf("bad %s: %v", name, err), input, hint)
+}
+
+// InvalidQuery reports a name query that does not parse
+func InvalidQuery(query string, err error) *APIError {
+	return NewError(http.StatusBadRequest, ErrInvalidQuery, err.Error(), query,
+		"filters are hue:, chroma: or lightness: with a range like 200-240, >30 or <=40")
+}
+
+// InvalidMessage reports a websocket message that is malformed or of an unknown type
+func InvalidMessage(message string, input string, hint string) *APIError {
+	if len(input) > maxErrorInput {
+		input = input[:maxErrorInput] + "..."
+	}
+	return NewError(http.StatusBadRequest, ErrInvalidMessage, message, input, hint)
+}
+
+// maxErrorInput is the longest input echoed in an error
+const maxErrorInput = 200
+
+// InternalError reports a server fault, the cause is logged and not exposed
+func InternalError(err error) *APIError {
+	slog.Error("internal error", "err", err)
+	return NewError(http.StatusInternalServerError, ErrInternal, "internal server error", "", "retry later")
+}
+
+// HandleError is the echo error handler, it answers every error with the envelope
+func HandleError(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	var apiErr *APIError
+	var httpErr *echo.HTTPError
+
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.As(err, &httpErr):
+		apiErr = httpError(httpErr, c)
+	default:
+		apiErr = InternalError(err)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(apiErr.Status)
+	} else {
+		err = c.JSON(apiErr.Status, ErrorEnvelope{apiErr})
+	}
+	if err != nil {
+		requestLog(c).Debug("write error response", "err", err)
+	}
+}
+
+// *** HELPER FUNCTIONS ***
+
+// httpError converts the errors of echo itself, e.g. an unknown route
+func httpError(e *echo.HTTPError, c echo.Context) *APIError {
+	message := http.StatusText(e.Code)
+	if m, ok := e.Message.(string); ok {
+		message = m
+	}
+
+	switch {
+	case e.Code == http.StatusNotFound:
+		return NewError(e.Code, ErrNotFound, message, c.Request().URL.Path, "see / for the routes")
+	case e.Code == http.StatusMethodNotAllowed:
+		return NewError(e.Code, ErrMethod, message, c.Request().Method, "")
+	case e.Code == http.StatusRequestEntityTooLarge:
+		return NewError(e.Code, ErrTooLarge, message, "", "the body limit is set by -max-body")
+	case e.Code >= 500:
+		return InternalError(e)
+	default:
+		return NewError(e.Code, ErrInvalidParam, message, "", "")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codcodea/cc/types"
+)
+
+// fields.go holds the field selection of the color response (?fields=) and its compact mode (?compact=true).
+// getColor only computes the selected sections, a client needing the name alone skips
+// the catalog searches and the gradient:
+// - ?fields=names,ral,mono includes only these sections, answered as a types.Partial
+// - ?fields=-mono,-pan includes all sections but these
+// - ?compact=true answers a flat types.Compact with the codes of the catalog matches instead of the records
+
+// Fields is a set of response sections
+type Fields uint16
+
+const (
+	FieldBase        Fields = 1 << iota // base color, name and description
+	FieldNames                          // the 5 nearest names
+	FieldMono                           // the gradient
+	FieldRAL                            // nearest RAL
+	FieldPAN                            // nearest Pantone
+	FieldNCS                            // nearest NCS
+	FieldConversions                    // rgb, hsl, hsv, lab and cmyk strings
+
+	AllFields = FieldBase | FieldNames | FieldMono | FieldRAL | FieldPAN | FieldNCS | FieldConversions
+)
+
+// fieldNames maps the names of ?fields= to the sections
+var fieldNames = map[string]Fields{
+	"base":        FieldBase,
+	"names":       FieldNames,
+	"mono":        FieldMono,
+	"ral":         FieldRAL,
+	"pan":         FieldPAN,
+	"ncs":         FieldNCS,
+	"conversions": FieldConversions,
+}
+
+// ParseFields parses a ?fields= list, an empty list selects all sections
+func ParseFields(query string) (Fields, error) {
+	var include, exclude Fields
+
+	for _, name := range strings.Split(strings.ToLower(query), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		target := &include
+		if strings.HasPrefix(name, "-") {
+			target, name = &exclude, name[1:]
+		}
+
+		f, ok := fieldNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown field %q", name)
+		}
+		*target |= f
+	}
+
+	if include == 0 {
+		include = AllFields
+	}
+	if include&^exclude == 0 {
+		return 0, fmt.Errorf("no field selected")
+	}
+	return include &^ exclude, nil
+}
+
+// Has reports whether all sections of f are selected
+func (s Fields) Has(f Fields) bool {
+	return s&f == f
+}
+
+// String lists the sections in the order of fieldNames, e.g. "base,names"
+func (s Fields) String() string {
+	var names []string
+	for _, name := range []string{"base", "names", "mono", "ral", "pan", "ncs", "conversions"} {
+		if s.Has(fieldNames[name]) {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// renderColor shapes a getColor result for the selected sections and the compact mode
+func renderColor(res types.Response, opts Options) any {
+	fields := opts.Fields
+	if fields == 0 {
+		fields = AllFields
+	}
+
+	if opts.Compact {
+		return compactColor(res, fields)
+	}
+	if fields == AllFields {
+		return res
+	}
+
+	// Only the selected sections, an unselected one is absent rather than empty
+	out := types.Partial{Locale: res.Locale}
+	if fields.Has(FieldBase) {
+		out.Base = &res.Base
+	}
+	if fields.Has(FieldNames) {
+		out.Names = res.Names
+	}
+	if fields.Has(FieldMono) {
+		out.Mono = res.Mono
+	}
+
+	conv := types.PartialConversion{}
+	if fields.Has(FieldConversions) {
+		conv.RGB = res.Conversion.RGB
+		conv.HSL = res.Conversion.HSL
+		conv.HSV = res.Conversion.HSV
+		conv.LAB = res.Conversion.LAB
+		conv.CMYK = res.Conversion.CMYK
+	}
+	if fields.Has(FieldRAL) {
+		conv.RAL = &res.Conversion.RAL
+	}
+	if fields.Has(FieldPAN) {
+		conv.PAN = &res.Conversion.PAN
+	}
+	if fields.Has(FieldNCS) {
+		conv.NCS = &res.Conversion.NCS
+	}
+	if conv != (types.PartialConversion{}) {
+		out.Conversions = &conv
+	}
+	return out
+}
+
+// compactColor flattens a getColor result into types.Compact
+func compactColor(res types.Response, fields Fields) types.Compact {
+	c := types.Compact{
+		Color:  res.Base.Color.Color,
+		Locale: res.Locale,
+	}
+	if fields.Has(FieldBase) {
+		c.Name = res.Base.Name
+		c.Description = res.Base.Description
+	}
+	if fields.Has(FieldNames) {
+		c.Names = res.Names
+	}
+	if fields.Has(FieldMono) {
+		for _, m := range res.Mono {
+			c.Mono = append(c.Mono, m.Color.Color)
+		}
+	}
+	if fields.Has(FieldConversions) {
+		c.RGB = res.Conversion.RGB
+	}
+	if fields.Has(FieldRAL) {
+		c.RAL = res.Conversion.RAL.Name
+	}
+	if fields.Has(FieldPAN) {
+		c.PAN = res.Conversion.PAN.Name
+	}
+	if fields.Has(FieldNCS) {
+		c.NCS = res.Conversion.NCS.Name
+	}
+	return c
+}
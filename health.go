@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codcodea/cc/types"
+	"github.com/kyroy/kdtree"
+	"github.com/labstack/echo/v4"
+)
+
+// health.go holds the startup state of the server and the probes of the orchestrator:
+// - GET /healthz is the liveness probe, it fails when loading the catalogs failed
+// - GET /readyz is the readiness probe, it fails until the catalogs and the name index are loaded
+// - GET /v1/catalogs lists the loaded catalogs, to tell which data version is live
+// The server listens while the catalogs load, the API answers 503 not_ready until then (RequireReady).
+
+// CatalogStatus describes a loaded catalog
+type CatalogStatus struct {
+	Name     string    `json:"name"`               // tree name, e.g. NAM or PAN
+	File     string    `json:"file"`               // target JSON, a data directory path or embedded:
+	Snapshot string    `json:"snapshot,omitempty"` // KD tree snapshot the tree was loaded from, if any
+	Entries  int       `json:"entries"`
+	Checksum string    `json:"checksum"` // CRC-32 (IEEE) of the target JSON, as recorded in its snapshot
+	LoadedAt time.Time `json:"loadedAt"`
+	LoadMs   float64   `json:"loadMs"`
+	Bounds   struct {
+		Min types.LABjson `json:"min"`
+		Max types.LABjson `json:"max"`
+	} `json:"bounds"` // Lab bounding box of the entries
+}
+
+// Catalogs lists the loaded catalogs in load order, see LoadTrees
+var Catalogs []CatalogStatus
+
+// loadState is the outcome of LoadData
+var loadState struct {
+	mu    sync.RWMutex
+	err   error // first load error, nil while loading or when loaded
+	ready atomic.Bool
+}
+
+// LoadData loads the catalogs (LoadTrees) and the name index (LoadNameMap) and records the outcome for the probes
+func LoadData() error {
+	start := time.Now()
+
+	err := LoadTrees()
+	if err == nil {
+		err = LoadNameMap()
+	}
+	if err != nil {
+		loadState.mu.Lock()
+		loadState.err = err
+		loadState.mu.Unlock()
+		return err
+	}
+
+	loadState.ready.Store(true)
+	slog.Info("catalogs ready", "catalogs", len(Catalogs), "duration", time.Since(start))
+	return nil
+}
+
+// HandleHealth GET /healthz
+func HandleHealth(c echo.Context) error {
+	if err := loadError(); err != nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"status": "failed", "error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleReady GET /readyz
+func HandleReady(c echo.Context) error {
+	if err := loadError(); err != nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"status": "failed", "error": err.Error()})
+	}
+	if !loadState.ready.Load() {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"status": "loading"})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"status": "ready", "catalogs": len(Catalogs), "locales": LoadedLocales()})
+}
+
+// HandleCatalogs GET /v1/catalogs
+func HandleCatalogs(c echo.Context) error {
+	return c.JSON(http.StatusOK, Catalogs)
+}
+
+// RequireReady is the middleware answering 503 until the catalogs are loaded
+func RequireReady(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !loadState.ready.Load() {
+			c.Response().Header().Set("Retry-After", "1")
+			return NewError(http.StatusServiceUnavailable, ErrNotReady, "the color catalogs are loading", "", "retry shortly, see /readyz")
+		}
+		return next(c)
+	}
+}
+
+// *** HELPER FUNCTIONS ***
+
+func loadError() error {
+	loadState.mu.RLock()
+	defer loadState.mu.RUnlock()
+	return loadState.err
+}
+
+// addCatalog records the status of a loaded tree
+func addCatalog(name string, status CatalogStatus) {
+	status.Name = name
+	Catalogs = append(Catalogs, status)
+}
+
+// newCatalogStatus describes a tree loaded from jsonData (and snapshot, if set) since start
+func newCatalogStatus(file string, snapshot string, jsonData []byte, tree *kdtree.KDTree, start time.Time) CatalogStatus {
+	s := CatalogStatus{
+		File:     file,
+		Snapshot: snapshot,
+		Checksum: fmt.Sprintf("%08x", crc32.ChecksumIEEE(jsonData)),
+		LoadedAt: time.Now().UTC(),
+		LoadMs:   float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	points := tree.Points()
+	s.Entries = len(points)
+	if len(points) == 0 {
+		return s
+	}
+
+	min := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	max := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range points {
+		for i := 0; i < 3; i++ {
+			min[i] = math.Min(min[i], p.Dimension(i))
+			max[i] = math.Max(max[i], p.Dimension(i))
+		}
+	}
+	s.Bounds.Min = types.LABjson{L: min[0], A: min[1], B: min[2]}
+	s.Bounds.Max = types.LABjson{L: max[0], A: max[1], B: max[2]}
+	return s
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// index.go holds the inverted index of the name search.
+// Scanning every name per query (rankMatches) gets slow as locales and catalogs grow,
+// so each locale gets an index built once at startup:
+// - grams: every 1, 2 and 3 byte substring of a name -> the names containing it
+// - words: every distinct name word -> the names containing it, for the typo tolerant match
+// - pairs: every rune pair of a name word, and its first rune -> the words containing it
+// A query only ranks the candidates found in the index, with the same matchName rules as the scan.
+// For a typo only the maxFuzzyWords words sharing the most rune pairs with it are checked,
+// below that the result is identical to rankMatches, see index_test.go (go test -bench .).
+
+// NameIndex is the inverted index of one name catalog
+type NameIndex struct {
+	names []Colorful
+	grams map[uint32][]int32 // packed gram, see gramKey -> name positions, ascending
+	words map[string][]int32 // name word -> name positions, ascending
+	vocab [][]rune           // distinct name words
+	pairs map[uint64][]int32 // rune pair, see pairKey -> vocab positions, ascending
+	lch   [][3]float32       // LCh coordinates of the names for the semantic filters, see query.go
+}
+
+// maxFuzzyWords caps the vocabulary words compared with a query word for its typo tolerance
+const maxFuzzyWords = 512
+
+// Indexes holds the name index per locale, see LoadNameMap
+var Indexes = make(map[string]*NameIndex)
+
+// NewNameIndex indexes the lower case names
+func NewNameIndex(names []Colorful) *NameIndex {
+	x := &NameIndex{
+		names: names,
+		words: make(map[string][]int32),
+		lch:   make([][3]float32, len(names)),
+	}
+	grams := make(map[uint32]*[]int32) // a single map lookup per gram while building
+
+	for i, c := range names {
+		pos := int32(i)
+		name := c.Lower
+
+		h, chroma, l := c.Color.Hcl()
+		x.lch[i] = [3]float32{float32(l * 100), float32(chroma * 100), float32(h)}
+
+		for n := 1; n <= 3; n++ {
+			for j := 0; j+n <= len(name); j++ {
+				postings := grams[gramKey(name[j:j+n])]
+				if postings == nil {
+					postings = new([]int32)
+					grams[gramKey(name[j:j+n])] = postings
+				}
+				*postings = appendOnce(*postings, pos)
+			}
+		}
+		for _, w := range strings.Fields(name) {
+			x.words[w] = appendOnce(x.words[w], pos)
+		}
+	}
+	x.grams = make(map[uint32][]int32, len(grams))
+	for key, postings := range grams {
+		x.grams[key] = *postings
+	}
+
+	words := make([]string, 0, len(x.words))
+	for w := range x.words {
+		words = append(words, w)
+	}
+	slices.Sort(words) // before the rune conversion, comparing []rune as strings allocates
+	x.vocab = make([][]rune, len(words))
+	for i, w := range words {
+		x.vocab[i] = []rune(w)
+	}
+
+	x.pairs = make(map[uint64][]int32)
+	for i, v := range x.vocab {
+		for _, key := range wordPairs(v) {
+			x.pairs[key] = appendOnce(x.pairs[key], int32(i))
+		}
+	}
+
+	return x
+}
+
+// Search returns the names matching the normalized query, ranked like rankMatches
+func (x *NameIndex) Search(query string) []NameMatch {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return []NameMatch{}
+	}
+
+	// Every query word must match, intersect the candidates of all words
+	var candidates []int32
+	for i, w := range words {
+		c := x.wordCandidates(w)
+		if i == 0 {
+			candidates = c
+		} else {
+			candidates = intersect(candidates, c)
+		}
+		if len(candidates) == 0 {
+			return []NameMatch{}
+		}
+	}
+
+	matches := []NameMatch{}
+	for _, pos := range candidates {
+		if m, ok := matchName(query, words, x.names[pos].Lower); ok {
+			m.Index = int(pos)
+			matches = append(matches, m)
+		}
+	}
+
+	sortMatches(matches, x.names)
+	return matches
+}
+
+// wordCandidates returns the names that may match a query word, as substring or within its typo tolerance
+func (x *NameIndex) wordCandidates(w string) []int32 {
+	// Substring: the names holding every gram of the word
+	var substring []int32
+	if len(w) <= 3 {
+		substring = x.grams[gramKey(w)]
+	} else {
+		for j := 0; j+3 <= len(w); j++ {
+			postings := x.grams[gramKey(w[j:j+3])]
+			if j == 0 {
+				substring = postings
+			} else {
+				substring = intersect(substring, postings)
+			}
+			if len(substring) == 0 {
+				break
+			}
+		}
+	}
+
+	// Typo: the names holding a word within the edit distance of w, see fuzzyWord
+	limit := maxEdits(w)
+	if limit == 0 {
+		return substring
+	}
+
+	r := []rune(w)
+	f := newFuzzyMatcher(r)
+	found := [][]int32{substring}
+	for _, i := range x.fuzzyVocab(r, limit) {
+		v := x.vocab[i]
+		if len(v) < len(r)-limit {
+			continue
+		}
+		cut := v
+		if len(cut) > len(r)+limit {
+			cut = cut[:len(r)+limit]
+		}
+		if f.prefixDistance(cut, limit) <= limit {
+			found = append(found, x.words[string(v)])
+		}
+	}
+	return merge(found)
+}
+
+// fuzzyVocab returns the positions of the vocabulary words that may be within limit edits of a prefix,
+// at most maxFuzzyWords of them, those sharing the most rune pairs with the word first.
+// An edit changes at most two pairs, so a word sharing fewer than all but 2*limit of them is too far.
+func (x *NameIndex) fuzzyVocab(word []rune, limit int) []int32 {
+	keys := wordPairs(word)
+	least := max(1, len(keys)-2*limit)
+
+	shared := make([]uint16, len(x.vocab))
+	touched := []int32{}
+	for _, key := range keys {
+		for _, i := range x.pairs[key] {
+			if shared[i] == 0 {
+				touched = append(touched, i)
+			}
+			shared[i]++
+		}
+	}
+
+	candidates := touched[:0]
+	for _, i := range touched {
+		if int(shared[i]) >= least {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) > maxFuzzyWords {
+		sort.Slice(candidates, func(a, b int) bool {
+			i, j := candidates[a], candidates[b]
+			if shared[i] != shared[j] {
+				return shared[i] > shared[j]
+			}
+			return i < j
+		})
+		candidates = candidates[:maxFuzzyWords]
+	}
+	return candidates
+}
+
+// fuzzyMatcher computes the edit distance of a query word to the prefixes of a text
+// with the bit-parallel algorithm of Myers (Hyyrö's variant for edit distance).
+// It gives the same distance as levenshtein, about ten times faster for the vocabulary scan.
+type fuzzyMatcher struct {
+	word  []rune
+	m     int
+	ascii [128]uint64     // match bit masks of the ASCII runes of word
+	other map[rune]uint64 // and of the others
+}
+
+func newFuzzyMatcher(word []rune) *fuzzyMatcher {
+	f := &fuzzyMatcher{word: word, m: len(word), other: make(map[rune]uint64)}
+	if f.m > 64 {
+		return f // too long for the bit masks, prefixDistance falls back to levenshtein
+	}
+	for i, c := range word {
+		if c < 128 {
+			f.ascii[c] |= 1 << i
+		} else {
+			f.other[c] |= 1 << i
+		}
+	}
+	return f
+}
+
+// prefixDistance returns the smallest edit distance of the word to a prefix of text, or > limit
+func (f *fuzzyMatcher) prefixDistance(text []rune, limit int) int {
+	if f.m > 64 {
+		return levenshtein(f.word, text, limit+1)
+	}
+	if f.m == 0 {
+		return 0
+	}
+
+	mask := ^uint64(0) >> (64 - f.m)
+	high := uint64(1) << (f.m - 1)
+
+	vp, vn := mask, uint64(0)
+	score := f.m
+	best := score
+
+	for _, c := range text {
+		var eq uint64
+		if c < 128 {
+			eq = f.ascii[c]
+		} else {
+			eq = f.other[c]
+		}
+
+		xv := eq | vn
+		xh := (((eq & vp) + vp) ^ vp) | eq
+		hp := vn | ^(xh | vp)
+		hn := vp & xh
+
+		if hp&high != 0 {
+			score++
+		} else if hn&high != 0 {
+			score--
+		}
+
+		hp = (hp << 1) | 1 // the top row grows by one per text rune, a global alignment
+		hn = hn << 1
+		vp = (hn | ^(xv | hp)) & mask
+		vn = hp & xv & mask
+
+		best = min(best, score)
+	}
+	return best
+}
+
+// *** HELPER FUNCTIONS ***
+
+// gramKey packs a gram of 1 to 3 bytes and its length into a map key
+func gramKey(g string) uint32 {
+	key := uint32(len(g)) << 24
+	for i := 0; i < len(g); i++ {
+		key |= uint32(g[i]) << (16 - 8*i)
+	}
+	return key
+}
+
+// wordPairs returns the distinct rune pairs of a word, the first rune paired with 0 included
+func wordPairs(word []rune) []uint64 {
+	keys := make([]uint64, 0, len(word))
+	prev := rune(0)
+	for _, c := range word {
+		if key := pairKey(prev, c); !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+		prev = c
+	}
+	return keys
+}
+
+// pairKey packs two runes into a map key
+func pairKey(a rune, b rune) uint64 {
+	return uint64(uint32(a))<<32 | uint64(uint32(b))
+}
+
+// appendOnce appends pos unless it is already the last element, names are indexed in order
+func appendOnce(list []int32, pos int32) []int32 {
+	if len(list) > 0 && list[len(list)-1] == pos {
+		return list
+	}
+	return append(list, pos)
+}
+
+// intersect returns the common elements of two ascending lists
+func intersect(a []int32, b []int32) []int32 {
+	out := make([]int32, 0, min(len(a), len(b)))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// merge returns the elements of the lists, ascending and without duplicates
+func merge(lists [][]int32) []int32 {
+	if len(lists) == 1 {
+		return lists[0]
+	}
+
+	n := 0
+	for _, l := range lists {
+		n += len(l)
+	}
+	out := make([]int32, 0, n)
+	for _, l := range lists {
+		out = append(out, l...)
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// searchQueries mix exact words, phrases, prefixes, typos and misses
+var searchQueries = []string{"blue", "sea green", "mdnight", "grren", "bleu", "gr", "dark olive", "pastel pink rose", "xyz", "ljusblå"}
+
+func TestNameIndexSearch(t *testing.T) {
+	loadTestData(t)
+
+	for _, locale := range LoadedLocales() {
+		names, index := Names[locale], Indexes[locale]
+		for _, q := range searchQueries {
+			q = normalizeQuery(q)
+			scan, indexed := rankMatches(q, names), index.Search(q)
+			if !slices.Equal(scan, indexed) {
+				t.Errorf("%s %q: index has %d matches, the scan %d", locale, q, len(indexed), len(scan))
+			}
+		}
+	}
+
+	// The typos must still find the word
+	for _, q := range []string{"grren", "bleu"} {
+		if len(Indexes[DefaultLocale].Search(q)) == 0 {
+			t.Errorf("no match for %q", q)
+		}
+	}
+}
+
+// baselineScan is the search before the index and the ranking, as in the first ColorLookUp:
+// every name holding every query word as a substring, in catalog order
+func baselineScan(query string, names []Colorful) []ColorfulJson {
+	words := strings.Split(query, " ")
+	result := []ColorfulJson{}
+
+	for _, c := range names {
+		match := true
+		for _, w := range words {
+			if w == "" {
+				continue
+			}
+			if !strings.Contains(c.Lower, w) {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			result = append(result, ColorfulJson{Name: c.Name, Hex: c.Color.Hex()})
+		}
+	}
+	return result
+}
+
+func BenchmarkBaselineScan(b *testing.B) {
+	loadTestData(b)
+	names := Names[DefaultLocale]
+
+	for _, q := range searchQueries {
+		b.Run(q, func(b *testing.B) {
+			q := normalizeQuery(q)
+			for i := 0; i < b.N; i++ {
+				baselineScan(q, names)
+			}
+		})
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	loadTestData(b)
+	names := Names[DefaultLocale]
+
+	for _, q := range searchQueries {
+		b.Run(q, func(b *testing.B) {
+			q := normalizeQuery(q)
+			for i := 0; i < b.N; i++ {
+				rankMatches(q, names)
+			}
+		})
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	loadTestData(b)
+	index := Indexes[DefaultLocale]
+
+	for _, q := range searchQueries {
+		b.Run(q, func(b *testing.B) {
+			q := normalizeQuery(q)
+			for i := 0; i < b.N; i++ {
+				index.Search(q)
+			}
+		})
+	}
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"os"
+	"log/slog"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	pk "github.com/codcodea/cc/db"
 	"github.com/codcodea/cc/types"
 	"github.com/kyroy/kdtree"
 	"github.com/lucasb-eyer/go-colorful"
@@ -16,31 +18,57 @@ import (
 
 type FileName struct {
 	Name string
-	Path string
+	Path string // relative to the data directory, see DataDir
 }
 
 type Colorful struct {
 	Name  string
+	Lower string // lower case name for the text search
 	Color colorful.Color
+	Lab   types.LABjson // catalog Lab coordinates
+	Good  bool          // preferred name, see isGoodName
+
+	lch [3]float64 // Luv LCh of Color, the key of the pre sort, computed once
 }
 
 var (
-	NAM = FileName{"NAM", "/Users/fhalsius/Documents/Code/go/mycolorpicker/db/target/colornames.json"}
-	RAL = FileName{"RAL", "/Users/fhalsius/Documents/Code/go/mycolorpicker/db/target/RAL_PLUS_CIELAB1931_sRGB.json"}
-	PAN = FileName{"PAN", "/Users/fhalsius/Documents/Code/go/mycolorpicker/db/target/pantone.json"}
-	NCS = FileName{"NCS", "/Users/fhalsius/Documents/Code/go/mycolorpicker/db/target/ncs.json"}
+	NAM = FileName{"NAM", "target/colornames.json"}
+	RAL = FileName{"RAL", "target/RAL_PLUS_CIELAB1931_sRGB.json"}
+	PAN = FileName{"PAN", "target/pantone.json"}
+	NCS = FileName{"NCS", "target/ncs.json"}
 )
 
+// PantoneCatalog is a Pantone sub-catalog that can be selected per request.
+// Variant is the name used in the request and reported in the match record.
+type PantoneCatalog struct {
+	Variant string
+	File    FileName
+}
+
+// PantoneCatalogs lists the Pantone sub-catalogs in order of preference, the catalogs of kind pantone
+// with a variant in db/manifest.json, see LoadTrees. "pms" is the default catalog and is always loaded.
+var PantoneCatalogs = []PantoneCatalog{
+	{"pms", PAN},
+}
+
+// DefaultPantone is used when a request does not select any Pantone sub-catalog
+var DefaultPantone = []string{"pms"}
+
+// DataDir overrides the embedded catalogs with files from a directory laid out like db/.
+// Empty means the embedded catalogs only, see db/embed.go.
+var DataDir = ""
+
 var (
 	Trees = make(map[string]*kdtree.KDTree) // map of all serach trees
-	Names = []Colorful{}                    // map of all color names <name, hex>
+	Names = make(map[string][]Colorful)     // all color names per locale, see LoadNameMap
 )
 
 // init.go holds the initialization logic for the server
 
 // When the server starts:
-// - each tree is loaded from a JSON file
-// - each tree is stored as a binary KD tree 
+// - each file is read from DataDir if present there, otherwise from the catalogs embedded in the binary
+// - each tree is loaded from its binary snapshot (.kdt, written by build-db), or from the JSON file as a fallback
+// - each tree is stored as a balanced binary KD tree 
 // - each tree is stored in memory
 // - on subsequent requests the memory trees are used for search and retrieval
 
@@ -49,96 +77,237 @@ var (
 // Library and methods: https://github.com/kyroy/kdtree
 
 func LoadTrees() error {
+	manifest, err := pk.ReadManifest(DataDir)
+	if err != nil {
+		return fmt.Errorf("reading the catalog manifest: %w", err)
+	}
+	if PantoneCatalogs, err = pantoneCatalogs(manifest); err != nil {
+		return err
+	}
+	if NameCatalogs, err = nameCatalogs(manifest); err != nil {
+		return err
+	}
+
 	filePaths := []FileName{NAM, RAL, PAN, NCS}
 
 	for _, f := range filePaths {
-		tree, err := LoadColorTree(f.Path)
+		tree, status, err := LoadColorTree(f.Path)
 		if err != nil {
-			fmt.Println("Error rebuilding KD tree:", err)
+			slog.Error("rebuilding KD tree", "file", f.Path, "err", err)
 			return err
 		}
 		Trees[f.Name] = tree
+		addCatalog(f.Name, status)
+	}
+
+	// Optional Pantone sub-catalogs, skipped when the target file is missing
+	for _, p := range PantoneCatalogs {
+		if err := loadOptionalTree(p.File, "Pantone catalog "+p.Variant); err != nil {
+			return err
+		}
+	}
+
+	// Optional name catalogs per locale (locale.go)
+	for _, n := range NameCatalogs {
+		if err := loadOptionalTree(n.File, "Name catalog "+n.Locale); err != nil {
+			return err
+		}
 	}
+	initLocales()
 	return nil
 }
 
-func LoadColorTree(filePath string) (*kdtree.KDTree, error) {
-	// Read and parse JSON
-	jsonData, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, err
+// pantoneCatalogs lists the Pantone sub-catalogs of the manifest, in its order
+func pantoneCatalogs(m *pk.Manifest) ([]PantoneCatalog, error) {
+	catalogs := []PantoneCatalog{}
+	for _, c := range m.Catalogs {
+		if c.Variant != "" {
+			catalogs = append(catalogs, PantoneCatalog{c.Variant, FileName{c.Name, m.DataPath(c)}})
+		}
 	}
-	var records []types.JSONRecord
+	if !slices.Contains(catalogs, PantoneCatalog{"pms", PAN}) {
+		return nil, fmt.Errorf("the manifest has no Pantone catalog %s with variant pms", PAN.Path)
+	}
+	return catalogs, nil
+}
 
-	if err := json.Unmarshal(jsonData, &records); err != nil {
-		return nil, err
+// loadOptionalTree loads a catalog unless it is already loaded or its target file is missing
+func loadOptionalTree(f FileName, label string) error {
+	if _, ok := Trees[f.Name]; ok {
+		return nil
 	}
-	// Create and populate a KD tree
-	tree := kdtree.New(nil)
-	for _, record := range records {
-		p := [3]float64{record.Lab.L, record.Lab.A, record.Lab.B}
-		point := types.NewPoint(record.Name, p)
-		tree.Insert(point)
+	if !pk.HasData(DataDir, f.Path) {
+		slog.Info("catalog not found, skipping", "catalog", label)
+		return nil
 	}
-	return tree, nil
+	tree, status, err := LoadColorTree(f.Path)
+	if err != nil {
+		slog.Error("rebuilding KD tree", "file", f.Path, "err", err)
+		return err
+	}
+	Trees[f.Name] = tree
+	addCatalog(f.Name, status)
+	return nil
 }
 
-func LoadNameMap() error {
-	var fileNAME = "/Users/fhalsius/Documents/Code/go/mycolorpicker/db/source/colornames.csv"
+// ParsePantone parses a comma separated list of Pantone sub-catalogs, e.g. "pms".
+// An empty list selects DefaultPantone. Unknown or unloaded variants are an error.
+func ParsePantone(query string) ([]string, error) {
+	if strings.TrimSpace(query) == "" {
+		return DefaultPantone, nil
+	}
 
-	csvFile, err := os.Open(fileNAME)
-	if err != nil {
-		fmt.Println("Error opening CSV file:", err)
-		return err
+	variants := []string{}
+	for _, v := range strings.Split(strings.ToLower(query), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" || slices.Contains(variants, v) {
+			continue
+		}
+		tree := pantoneTree(v)
+		if tree == "" {
+			return nil, fmt.Errorf("unknown pantone catalog: %s", v)
+		}
+		if _, ok := Trees[tree]; !ok {
+			return nil, fmt.Errorf("pantone catalog not loaded: %s", v)
+		}
+		variants = append(variants, v)
+	}
+	if len(variants) == 0 {
+		return DefaultPantone, nil
+	}
+	return variants, nil
+}
+
+// pantoneTree returns the tree name of a Pantone variant, or "" if unknown
+func pantoneTree(variant string) string {
+	for _, p := range PantoneCatalogs {
+		if p.Variant == variant {
+			return p.File.Name
+		}
 	}
-	defer csvFile.Close()
+	return ""
+}
 
-	reader := csv.NewReader(csvFile)
+// LoadColorTree loads the KD tree of a catalog JSON file.
+// The snapshot next to it is used when it was built from the same JSON,
+// a missing, stale or corrupt snapshot falls back to parsing the JSON.
+// The status describes the loaded catalog, see GET /catalogs.
+func LoadColorTree(filePath string) (*kdtree.KDTree, CatalogStatus, error) {
+	start := time.Now()
 
-	_, err = reader.Read() // skip first line
+	// Read JSON
+	jsonData, from, err := pk.ReadData(DataDir, filePath)
 	if err != nil {
-		fmt.Println("Error reading CSV file:", err)
-		return err
+		return nil, CatalogStatus{}, err
 	}
 
-	for {
-		line, err := reader.Read()
-		if err != nil {
-			break // End of file.
+	snapshot := pk.SnapshotPath(filePath)
+	if data, snapshotFrom, err := pk.ReadData(DataDir, snapshot); err == nil {
+		tree, n, err := pk.SnapshotTree(data, jsonData)
+		if err == nil {
+			slog.Info("catalog loaded", "file", snapshotFrom, "points", n, "duration", time.Since(start))
+			return tree, newCatalogStatus(from, snapshotFrom, jsonData, tree, start), nil
 		}
+		slog.Warn("snapshot ignored", "file", snapshotFrom, "err", err)
+	}
 
-		// Parse
-		nam := strings.ToLower(line[0])
-		hex := strings.ToLower(line[1])
+	// Parse JSON
+	var records []types.JSONRecord
 
-		// Load color into a colorful.Color struct
-		c, err := colorful.Hex(string(hex))
-		if err != nil {
-			fmt.Println("Load Names conversion err:", err)
-			break
+	if err := json.Unmarshal(jsonData, &records); err != nil {
+		return nil, CatalogStatus{}, err
+	}
+	// Create a balanced KD tree
+	points := make([]kdtree.Point, len(records))
+	for i, record := range records {
+		points[i] = types.NewRecordPoint(record)
+	}
+	tree := kdtree.New(points)
+
+	slog.Info("catalog loaded", "file", from, "points", len(points), "duration", time.Since(start))
+	return tree, newCatalogStatus(from, "", jsonData, tree, start), nil
+}
+
+
+// LoadNameMap builds the Names list and its inverted index (index.go) for the text search (ColorLookUp) of every loaded locale.
+// The catalogs are loaded once by LoadTrees, the KD trees and the text index share the same records.
+func LoadNameMap() error {
+	if _, ok := Trees[NAM.Name]; !ok {
+		return fmt.Errorf("name catalog %s not loaded", NAM.Path)
+	}
+
+	for _, locale := range LoadedLocales() {
+		Names[locale] = loadNames(Trees[nameTree(locale)])
+		Indexes[locale] = NewNameIndex(Names[locale])
+		slog.Info("color names loaded", "locale", locale, "names", len(Names[locale]))
+	}
+	return nil
+}
+
+// loadNames builds the text index of a name catalog.
+// Good names come first, then the names are pre sorted by lightness, hue and chroma (light to dark).
+func loadNames(tree *kdtree.KDTree) []Colorful {
+	points := tree.Points()
+	names := make([]Colorful, 0, len(points))
+
+	for _, p := range points {
+		point := p.(types.CustomPoint)
+
+		// Prefer the exact hex of the record over a Lab round trip
+		c := colorful.Lab(point.Lab.LAB[0], point.Lab.LAB[1], point.Lab.LAB[2]).Clamped()
+		if point.Meta != nil && point.Meta.Hex != "" {
+			if h, err := parseHex(point.Meta.Hex); err == nil {
+				c = h
+			}
 		}
 
-		// Store in map
-		Names = append(Names, Colorful{nam, c})
+		l, ch, h := c.LuvLCh()
+		names = append(names, Colorful{
+			Name:  point.Name,
+			Lower: strings.ToLower(point.Name),
+			Color: c,
+			Lab:   types.LABjson{L: point.Lab.LAB[0], A: point.Lab.LAB[1], B: point.Lab.LAB[2]},
+			Good:  isGoodName(point),
+			lch:   [3]float64{l, ch, h},
+		})
 	}
 
 	// Pre sort the names
-	sort.Slice(Names, func(i, j int) bool {
-		l1, c1, h1 := Names[i].Color.LuvLCh()
-		l2, c2, h2 := Names[j].Color.LuvLCh()
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Good != names[j].Good {
+			return names[i].Good
+		}
+		l1, c1, h1 := names[i].lch[0], names[i].lch[1], names[i].lch[2]
+		l2, c2, h2 := names[j].lch[0], names[j].lch[1], names[j].lch[2]
 		if l1 != l2 {
-			return l1 < l2
+			return l1 > l2
 		}
 		if h1 != h2 {
-			return h1 < h2
+			return h1 > h2
 		}
 		if c1 != c2 {
-			return c1 < c2
+			return c1 > c2
 		}
-		return false
+		return names[i].Name < names[j].Name
 	})
 
-	slices.Reverse(Names);
-	fmt.Println("Color names loaded:", len(Names))
-	return nil
+	return names
+}
+
+// parseHex parses a #rrggbb color like colorful.Hex, without its fmt.Sscanf that is slow over 30k names
+func parseHex(hex string) (colorful.Color, error) {
+	if len(hex) != 7 || hex[0] != '#' {
+		return colorful.Hex(hex)
+	}
+	v, err := strconv.ParseUint(hex[1:], 16, 32)
+	if err != nil {
+		return colorful.Color{}, err
+	}
+	const factor = 1.0 / 255.0
+	return colorful.Color{R: float64(v>>16) * factor, G: float64(v>>8&0xff) * factor, B: float64(v&0xff) * factor}, nil
+}
+
+// isGoodName reports whether a name is marked in the "good name" column of colornames.csv
+func isGoodName(p types.CustomPoint) bool {
+	return p.Meta != nil && slices.Contains(p.Meta.Tags, "good")
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	pk "github.com/codcodea/cc/db"
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+var loadOnce sync.Once
+var loadErr error
+
+// loadTestData loads the embedded catalogs and the name index once per test binary
+func loadTestData(t testing.TB) {
+	t.Helper()
+	loadOnce.Do(func() { loadErr = LoadData() })
+	if loadErr != nil {
+		t.Fatal(loadErr)
+	}
+}
+
+func TestLoadColorTreeSnapshot(t *testing.T) {
+	source, _, err := pk.ReadData("", NCS.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, _, err := pk.ReadData("", pk.SnapshotPath(NCS.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), snapshot...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	edited := append(append([]byte(nil), source...), '\n')
+
+	tests := []struct {
+		name     string
+		source   []byte
+		snapshot []byte // nil for none, the embedded one is then tried
+		used     bool
+	}{
+		{"valid", source, snapshot, true},
+		{"corrupt crc", source, corrupt, false},
+		{"stale embedded", edited, nil, false},
+	}
+
+	defer func(dir string) { DataDir = dir }(DataDir)
+
+	for _, tt := range tests {
+		DataDir = t.TempDir()
+		os.MkdirAll(filepath.Join(DataDir, "target"), 0o755)
+		os.WriteFile(filepath.Join(DataDir, filepath.FromSlash(NCS.Path)), tt.source, 0o644)
+		if tt.snapshot != nil {
+			os.WriteFile(filepath.Join(DataDir, filepath.FromSlash(pk.SnapshotPath(NCS.Path))), tt.snapshot, 0o644)
+		}
+
+		tree, status, err := LoadColorTree(NCS.Path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if used := status.Snapshot != ""; used != tt.used {
+			t.Errorf("%s: snapshot used %v, want %v", tt.name, used, tt.used)
+		}
+		if !strings.HasPrefix(status.File, DataDir) {
+			t.Errorf("%s: loaded from %s, want the data directory", tt.name, status.File)
+		}
+		if n := len(tree.Points()); n == 0 || n != status.Entries {
+			t.Errorf("%s: %d points, status has %d entries", tt.name, n, status.Entries)
+		}
+	}
+}
+
+func TestPantoneCatalogsFromManifest(t *testing.T) {
+	data, _, err := pk.ReadData("", "manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A sub-catalog added in the manifest of the data directory only
+	coated := `{"name": "PAN_C", "kind": "pantone", "source": "pantone_coated.txt", "target": "pantone_coated.json", "variant": "coated"},`
+	manifest := strings.Replace(string(data), `"catalogs": [`, `"catalogs": [`+coated, 1)
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0o644)
+
+	m, err := pk.ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogs, err := pantoneCatalogs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PantoneCatalog{{"coated", FileName{"PAN_C", "target/pantone_coated.json"}}, {"pms", PAN}}
+	if !reflect.DeepEqual(catalogs, want) {
+		t.Errorf("catalogs %v, want %v", catalogs, want)
+	}
+
+	// The embedded manifest has PMS only
+	m, err = pk.ReadManifest("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if catalogs, err := pantoneCatalogs(m); err != nil || !reflect.DeepEqual(catalogs, []PantoneCatalog{{"pms", PAN}}) {
+		t.Errorf("embedded catalogs %v, %v", catalogs, err)
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	for _, hex := range []string{"#000000", "#ffffff", "#0A7F3c", "#fff", "#12345", "#gggggg", "123456"} {
+		got, err := parseHex(hex)
+		want, wantErr := colorful.Hex(hex)
+		if (err != nil) != (wantErr != nil) || got != want {
+			t.Errorf("parseHex(%q) = %v, %v, want %v, %v", hex, got, err, want, wantErr)
+		}
+	}
+}
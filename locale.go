@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	pk "github.com/codcodea/cc/db"
+	"golang.org/x/text/language"
+)
+
+// locale.go holds the name catalogs per locale and the locale negotiation.
+// The catalogs are the entries of kind names with a locale in db/manifest.json.
+// English is the default catalog and is always loaded, the others are skipped when their target is missing.
+
+// NameCatalog is the name catalog of one locale
+type NameCatalog struct {
+	Locale string
+	File   FileName
+}
+
+// NameCatalogs lists the name catalogs of the manifest, DefaultLocale first, set by LoadTrees
+var NameCatalogs = []NameCatalog{{DefaultLocale, NAM}}
+
+// DefaultLocale is used when no requested locale is loaded
+const DefaultLocale = "en"
+
+var localeMatcher language.Matcher
+
+// LoadedLocales returns the locales with a loaded name catalog, DefaultLocale first
+func LoadedLocales() []string {
+	locales := []string{}
+	for _, n := range NameCatalogs {
+		if _, ok := Trees[n.File.Name]; ok {
+			locales = append(locales, n.Locale)
+		}
+	}
+	return locales
+}
+
+// ResolveLocale picks the name locale of a request: ?lang= first, then the Accept-Language header.
+// It falls back to DefaultLocale when neither matches a loaded catalog.
+func ResolveLocale(lang string, acceptLanguage string) string {
+	locales := LoadedLocales()
+	if localeMatcher == nil || len(locales) == 0 {
+		return DefaultLocale
+	}
+
+	var desired []language.Tag
+	if lang = strings.TrimSpace(lang); lang != "" {
+		if tag, err := language.Parse(lang); err == nil {
+			desired = append(desired, tag)
+		}
+	}
+	if tags, _, err := language.ParseAcceptLanguage(acceptLanguage); err == nil {
+		desired = append(desired, tags...)
+	}
+	if len(desired) == 0 {
+		return DefaultLocale
+	}
+
+	_, index, confidence := localeMatcher.Match(desired...)
+	if confidence == language.No {
+		return DefaultLocale
+	}
+	return locales[index]
+}
+
+// initLocales sets up the locale matcher for the loaded name catalogs, call after LoadTrees
+func initLocales() {
+	tags := []language.Tag{}
+	for _, l := range LoadedLocales() {
+		tags = append(tags, language.Make(l))
+	}
+	localeMatcher = language.NewMatcher(tags)
+}
+
+// nameCatalogs lists the name catalogs of the manifest, DefaultLocale first and the others in manifest order
+func nameCatalogs(m *pk.Manifest) ([]NameCatalog, error) {
+	catalogs := []NameCatalog{{DefaultLocale, NAM}}
+	found := false
+	for _, c := range m.Catalogs {
+		switch {
+		case c.Locale == "":
+		case c.Locale == DefaultLocale:
+			found = c.Name == NAM.Name && m.DataPath(c) == NAM.Path
+		case language.Make(c.Locale) == language.Und:
+			return nil, fmt.Errorf("name catalog %s has an invalid locale %q", c.Name, c.Locale)
+		default:
+			catalogs = append(catalogs, NameCatalog{c.Locale, FileName{c.Name, m.DataPath(c)}})
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("the manifest has no name catalog %s with locale %s", NAM.Path, DefaultLocale)
+	}
+	return catalogs, nil
+}
+
+// nameTree returns the tree name of the name catalog of a locale, DefaultLocale if not loaded
+func nameTree(locale string) string {
+	for _, n := range NameCatalogs {
+		if n.Locale == locale {
+			if _, ok := Trees[n.File.Name]; ok {
+				return n.File.Name
+			}
+		}
+	}
+	return NAM.Name
+}
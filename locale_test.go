@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	pk "github.com/codcodea/cc/db"
+)
+
+func TestResolveLocale(t *testing.T) {
+	loadTestData(t)
+
+	tests := []struct {
+		lang, accept string
+		want         string
+	}{
+		{"", "", "en"},
+		{"sv", "", "sv"},
+		{"sv-SE", "", "sv"},
+		{"", "sv-SE,sv;q=0.9,en;q=0.8", "sv"},
+		{"en", "sv", "en"},
+		{"fi", "", "en"},
+		{"", "de-DE,de;q=0.9", "en"},
+		{"not a tag", "sv", "sv"},
+	}
+	for _, tt := range tests {
+		if got := ResolveLocale(tt.lang, tt.accept); got != tt.want {
+			t.Errorf("ResolveLocale(%q, %q) = %q, want %q", tt.lang, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestGetColorNameLocale(t *testing.T) {
+	loadTestData(t)
+
+	tests := []struct {
+		hex, locale string
+		want        string
+	}{
+		{"#ff0000", "sv", "Röd"},
+		{"#add8e6", "sv", "Ljusblå"},
+		{"#801818", "sv", "Falu rödfärg"},
+		{"#add8e6", "en", "Light Blue"},
+		{"#add8e6", "de", "Light Blue"}, // not shipped, falls back to en
+	}
+	for _, tt := range tests {
+		res, err := getColor(tt.hex, Options{Locale: tt.locale, Fields: FieldBase})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Base.Color.Name; got != tt.want {
+			t.Errorf("name of %s in %s = %q, want %q", tt.hex, tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestNameCatalogsFromManifest(t *testing.T) {
+	data, _, err := pk.ReadData("", "manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A locale added in the manifest of the data directory only
+	de := `{"name": "NAM_de", "kind": "names", "source": "colornames.de.csv", "target": "colornames.de.json", "locale": "de"},`
+	manifest := strings.Replace(string(data), `"catalogs": [`, `"catalogs": [`+de, 1)
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0o644)
+
+	m, err := pk.ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogs, err := nameCatalogs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []NameCatalog{
+		{"en", NAM},
+		{"de", FileName{"NAM_de", "target/colornames.de.json"}},
+		{"sv", FileName{"NAM_sv", "target/colornames.sv.json"}},
+	}
+	if !reflect.DeepEqual(catalogs, want) {
+		t.Errorf("catalogs %v, want %v", catalogs, want)
+	}
+
+	// A repeated locale is rejected with the manifest
+	repeated := strings.Replace(manifest, `"locale": "de"`, `"locale": "sv"`, 1)
+	os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(repeated), 0o644)
+	if _, err := pk.ReadManifest(dir); err == nil {
+		t.Error("repeated locale sv accepted")
+	}
+}
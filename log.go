@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// log.go sets up the structured, levelled logging of the server (log/slog).
+// Every HTTP request gets an id (the X-Request-Id header, generated if the client sent none),
+// the access log line and the logs of its websocket session carry it as request_id.
+
+// SetupLogger installs the default logger, level is debug, info, warn or error and format is json or text
+func SetupLogger(level string, format string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("bad log level %q: %w", level, err)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("bad log format %q, use json or text", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// requestLog returns the logger of a request, with its request id
+func requestLog(c echo.Context) *slog.Logger {
+	id := c.Response().Header().Get(echo.HeaderXRequestID)
+	if id == "" {
+		id = c.Request().Header.Get(echo.HeaderXRequestID)
+	}
+	return slog.Default().With("request_id", id)
+}
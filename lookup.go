@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// lookup.go holds the JSON message protocol of the lookup socket (GET /lookup).
+// Every client message is a JSON object, every reply echoes the id of the message it answers:
+//
+//	-> {"type":"search","id":7,"query":"pastel blue","limit":50,"offset":0,"options":{"lang":"sv"}}
+//	<- {"type":"results","id":7,"query":"pastel blue","locale":"sv","total":120,"offset":0,"limit":50,"results":[...]}
+//	-> {"type":"cancel","id":7}
+//	<- {"type":"cancelled","id":7}
+//	<- {"type":"error","id":7,"error":{"code":"invalid_query","message":"bad filter hue:abc: ...",...}}
+//
+// A search supersedes the searches still pending or running, they are answered with "cancelled",
+// so a client typing fast only gets the results of its last keystroke.
+// Messages that are not JSON objects are plain queries of the first client version, answered by Send.
+
+// lookupHint is the hint of the errors of malformed messages
+const lookupHint = `send {"type":"search","id":1,"query":"sea green"} or {"type":"cancel","id":1}`
+
+// Message types of the lookup protocol
+const (
+	MsgSearch    = "search"    // client: rank the names matching query
+	MsgCancel    = "cancel"    // client: cancel the search with id
+	MsgResults   = "results"   // server: a page of ranked names
+	MsgCancelled = "cancelled" // server: the search was cancelled or superseded, no results follow
+	MsgError     = "error"     // server: the message could not be handled
+)
+
+// LookupMessage is a client message of the lookup protocol
+type LookupMessage struct {
+	Type    string          `json:"type"`
+	ID      json.RawMessage `json:"id,omitempty"` // any JSON value, echoed in the reply
+	Query   string          `json:"query,omitempty"`
+	Limit   int             `json:"limit,omitempty"` // default 50, at most 500
+	Offset  int             `json:"offset,omitempty"`
+	Options LookupOptions   `json:"options"`
+
+	plain bool // plain query of the first version, answered with a bare array
+}
+
+// LookupOptions are the per-message settings of a search
+type LookupOptions struct {
+	Lang string `json:"lang,omitempty"` // switches the locale of the session, see ResolveLocale
+}
+
+// LookupReply is a server message of the lookup protocol
+type LookupReply struct {
+	Type string          `json:"type"`
+	ID   json.RawMessage `json:"id,omitempty"`
+	*NameSearch
+	Error *APIError `json:"error,omitempty"` // the error envelope, see errors.go
+}
+
+// lookupQueue hands the latest search from the socket reader to the session worker
+type lookupQueue struct {
+	mu      sync.Mutex
+	pending *LookupMessage     // next search, nil if none
+	running *LookupMessage     // search in progress, nil if none
+	cancel  context.CancelFunc // cancels running
+	ready   chan struct{}      // signals a pending search, buffered 1
+}
+
+// Serve runs the lookup protocol on a socket until the client disconnects.
+// The session is only used by the worker goroutine, the reader never blocks on a search.
+func (s *FormSession) Serve(sock *Socket) {
+	ws := sock.Conn
+	q := &lookupQueue{ready: make(chan struct{}, 1)}
+	done := make(chan struct{})
+	defer close(done)
+
+	go s.work(ws, q, done)
+
+	for {
+		msg, err := sock.Receive()
+		var rejected *APIError
+		if errors.As(err, &rejected) {
+			reply(ws, LookupReply{Type: MsgError, Error: rejected})
+			continue
+		}
+		if err != nil {
+			s.Log.Debug("read socket", "err", err)
+			q.mu.Lock()
+			if q.cancel != nil {
+				q.cancel()
+			}
+			q.mu.Unlock()
+			return
+		}
+
+		// Plain queries of the first protocol version
+		if !strings.HasPrefix(strings.TrimSpace(msg), "{") {
+			if len(msg) > 1 {
+				q.push(ws, &LookupMessage{Type: MsgSearch, Query: msg, plain: true})
+			}
+			continue
+		}
+
+		var m LookupMessage
+		if err := json.Unmarshal([]byte(msg), &m); err != nil {
+			reply(ws, LookupReply{Type: MsgError, Error: InvalidMessage("malformed message: "+err.Error(), msg, lookupHint)})
+			continue
+		}
+
+		switch m.Type {
+		case MsgSearch:
+			if m.Limit < 0 || m.Limit > maxSearchLimit || m.Offset < 0 {
+				reply(ws, LookupReply{Type: MsgError, ID: m.ID, Error: InvalidMessage("limit must be 1 to 500 and offset 0 or more", msg, lookupHint)})
+				continue
+			}
+			if m.Limit == 0 {
+				m.Limit = lookupLimit
+			}
+			q.push(ws, &m)
+		case MsgCancel:
+			q.cancelID(ws, m.ID, msg)
+		default:
+			reply(ws, LookupReply{Type: MsgError, ID: m.ID, Error: InvalidMessage(fmt.Sprintf("unknown message type %q", m.Type), msg, lookupHint)})
+		}
+	}
+}
+
+// work answers the searches of the queue one at a time
+func (s *FormSession) work(ws *websocket.Conn, q *lookupQueue, done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-q.ready:
+		}
+
+		q.mu.Lock()
+		m := q.pending
+		q.pending = nil
+		ctx, cancel := context.WithCancel(context.Background())
+		q.running, q.cancel = m, cancel
+		q.mu.Unlock()
+
+		if m != nil {
+			s.search(ctx, ws, m)
+		}
+
+		q.mu.Lock()
+		q.running, q.cancel = nil, nil
+		q.mu.Unlock()
+		cancel()
+	}
+}
+
+// search runs one search on the session and replies, unless it was cancelled meanwhile
+func (s *FormSession) search(ctx context.Context, ws *websocket.Conn, m *LookupMessage) {
+	if m.plain {
+		Send(ctx, m.Query, s, ws)
+		return
+	}
+
+	if m.Options.Lang != "" {
+		s.Locale = ResolveLocale(m.Options.Lang, "")
+	}
+
+	err := ColorLookUp(ctx, m.Query, s)
+	if err != nil && ctx.Err() == nil {
+		reply(ws, LookupReply{Type: MsgError, ID: m.ID, Error: InvalidQuery(m.Query, err)})
+		return
+	}
+
+	if ctx.Err() != nil {
+		reply(ws, LookupReply{Type: MsgCancelled, ID: m.ID})
+		return
+	}
+
+	result := s.Result(m.Offset, m.Limit)
+	reply(ws, LookupReply{Type: MsgResults, ID: m.ID, NameSearch: &result})
+}
+
+// push queues a search, superseding the pending and the running one
+func (q *lookupQueue) push(ws *websocket.Conn, m *LookupMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pending != nil {
+		replyCancelled(ws, q.pending)
+	}
+	if q.cancel != nil {
+		q.cancel()
+	}
+	q.pending = m
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// cancelID cancels the pending or running search with the id, a cancel needs an id
+func (q *lookupQueue) cancelID(ws *websocket.Conn, id json.RawMessage, msg string) {
+	if len(id) == 0 || string(id) == "null" {
+		reply(ws, LookupReply{Type: MsgError, Error: InvalidMessage("cancel needs the id of the search", msg, lookupHint)})
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	switch {
+	case q.pending != nil && string(q.pending.ID) == string(id):
+		replyCancelled(ws, q.pending)
+		q.pending = nil
+	case q.running != nil && string(q.running.ID) == string(id):
+		q.cancel() // the worker replies cancelled
+	default:
+		reply(ws, LookupReply{Type: MsgError, ID: id, Error: NewError(http.StatusNotFound, ErrNotFound, "no pending search with this id", string(id), "")})
+	}
+}
+
+// *** HELPER FUNCTIONS ***
+
+// replyCancelled answers a superseded search, plain queries of the first version get no reply
+func replyCancelled(ws *websocket.Conn, m *LookupMessage) {
+	if !m.plain {
+		reply(ws, LookupReply{Type: MsgCancelled, ID: m.ID})
+	}
+}
+
+// reply sends a message, the socket serializes concurrent writes
+func reply(ws *websocket.Conn, r LookupReply) {
+	if err := websocket.JSON.Send(ws, r); err != nil {
+		slog.Debug("write socket", "err", err)
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"os"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -30,32 +34,86 @@ import (
 // Main is the entry point of the application.
 func main() {
 
-	// Load database of colors into a KD tree into memory on startup
-	if err := LoadTrees(); err != nil {
-		fmt.Println("Error loading KD trees:", err)
-		return
+	// Maintenance subcommand, see build.go, and the lookup subcommands, see cli.go
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "build-db":
+			os.Exit(BuildDB(os.Args[2:]))
+		case "color", "name", "code", "palette":
+			os.Exit(RunCLI(os.Args[1], os.Args[2:]))
+		}
 	}
 
-	if err := LoadNameMap(); err != nil {
-		fmt.Println("Error loading color names:", err)
-		return
+	// Flags, environment and config file, see config.go
+	cfg, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := SetupLogger(cfg.LogLevel, cfg.LogFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// The catalogs are embedded in the binary, a data directory overrides single files
+	DataDir = cfg.DataDir
+	ColorCache = NewLRU[string, CachedColor](cfg.CacheSize)
+
+	// Load database of colors into a KD tree into memory, the server listens meanwhile (health.go)
+	go func() {
+		if err := LoadData(); err != nil {
+			slog.Error("loading catalogs", "err", err)
+		}
+	}()
+
+	Sockets.Configure(cfg.SocketLimits())
+
+	limiter, err := NewRateLimiter(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
 	// REST API
 	app := echo.New()
+	app.HideBanner = true
+	app.HidePort = true
+	app.HTTPErrorHandler = HandleError
+	app.IPExtractor = cfg.IPExtractor() // the client IP of the rate limit and the access log, see -trusted-proxies
 
-	// app.Use(middleware.Logger())
+	// Request ids, metrics and the access log (metrics.go) see every request, including recovered panics
+	app.Use(middleware.RequestID())
+	app.Use(Instrument)
 	app.Use(middleware.Recover())
 	app.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"}, // add mycolorpicker.com in production 
+		AllowOrigins: cfg.CORSOrigins, // -cors-origins, add mycolorpicker.com in production 
 	}))
+	app.Use(middleware.BodyLimit(cfg.MaxBody))
 
 	app.GET("/", HandleRoot)
-	app.GET("/colors/:hex", HandleColor)
-	app.GET("/form", HandleLookup)
-	app.Logger.Fatal(app.Start(":4005"))
 
-	// Maintainace scripts for the color databases commented out 
-	// pk.CleanNAME();
-	// return 
+	// The API is versioned under /v1, the unversioned paths are kept as aliases of v1
+	for _, api := range []*echo.Group{app.Group(APIVersion), app.Group("")} {
+		api.GET("/colors/stream", HandleColorStream, RequireReady, limiter.Limit(UnitCost))
+		api.GET("/colors/:hex", HandleColor, RequireReady, limiter.Limit(UnitCost))
+		api.GET("/lookup", HandleLookup, RequireReady, limiter.Limit(UnitCost))
+		api.GET("/names/search", HandleNameSearch, RequireReady, limiter.Limit(SearchCost))
+		api.GET("/catalogs", HandleCatalogs, RequireReady)
+		api.GET("/openapi.json", HandleOpenAPI)
+		api.GET("/schema/response.json", HandleResponseSchema)
+	}
+	app.GET("/form", HandleLookup, RequireReady, limiter.Limit(UnitCost)) // path of the client feature branch
+	app.GET("/metrics", HandleMetrics)
+	app.GET("/healthz", HandleHealth)
+	app.GET("/readyz", HandleReady)
+
+	// Runs until SIGTERM, see server.go
+	if err := Serve(app, cfg); err != nil {
+		slog.Error("server stopped", "err", err)
+		os.Exit(1)
+	}
 }
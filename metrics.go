@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// metrics.go holds the Prometheus metrics of the server, served in the text exposition format on GET /metrics.
+// The few metric types needed are implemented here instead of pulling in the client library:
+// - request counts and latency histograms per route (the route pattern, not the path, to bound the series)
+// - KD tree search time per catalog tree and the time of an uncached getColor
+// - color cache counters and hit ratio, read from ColorCache when scraped
+// - active websocket sessions per socket
+
+// latencyBuckets are the histogram buckets in seconds, fine grained around the ~1ms of a color lookup
+var latencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1}
+
+var (
+	httpRequests = newMetric("cc_http_requests_total", "counter",
+		"HTTP requests by route, method and status.", "route", "method", "status")
+	httpSeconds = newMetric("cc_http_request_duration_seconds", "histogram",
+		"HTTP request latency by route, websocket sessions excluded.", "route")
+	kdSearchSeconds = newMetric("cc_kdtree_search_duration_seconds", "histogram",
+		"KD tree nearest neighbour search time by catalog tree.", "tree")
+	colorSeconds = newMetric("cc_color_lookup_duration_seconds", "histogram",
+		"Time of an uncached getColor, all catalog searches and the gradient.")
+	cacheHits = newMetric("cc_color_cache_hits_total", "counter",
+		"Color responses served from the cache.")
+	cacheMisses = newMetric("cc_color_cache_misses_total", "counter",
+		"Color responses computed by getColor.")
+	cacheEvictions = newMetric("cc_color_cache_evictions_total", "counter",
+		"Color responses evicted from the full cache.")
+	cacheEntries = newMetric("cc_color_cache_entries", "gauge",
+		"Color responses in the cache.")
+	cacheHitRatio = newMetric("cc_color_cache_hit_ratio", "gauge",
+		"Hits over lookups of the color cache since startup.")
+	wsSessions = newMetric("cc_websocket_sessions", "gauge",
+		"Open websocket sessions by socket.", "socket")
+)
+
+// HandleMetrics GET /metrics
+func HandleMetrics(c echo.Context) error {
+	stats := ColorCache.Stats()
+	cacheHits.Set(float64(stats.Hits))
+	cacheMisses.Set(float64(stats.Misses))
+	cacheEvictions.Set(float64(stats.Evictions))
+	cacheEntries.Set(float64(stats.Entries))
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		cacheHitRatio.Set(float64(stats.Hits) / float64(lookups))
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	c.Response().WriteHeader(http.StatusOK)
+	for _, m := range registry {
+		m.write(c.Response())
+	}
+	return nil
+}
+
+// Instrument is the middleware counting, timing and logging every request
+func Instrument(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+
+		err := next(c)
+		if err != nil {
+			c.Error(err) // write the error envelope now, to record its status
+		}
+		elapsed := time.Since(start)
+
+		route := c.Path()
+		if route == "" || route == "/*" {
+			route = "unmatched"
+		}
+		status := c.Response().Status
+
+		httpRequests.Add(1, route, c.Request().Method, strconv.Itoa(status))
+		if !c.IsWebSocket() {
+			httpSeconds.Observe(elapsed.Seconds(), route)
+		}
+
+		requestLog(c).Info("request",
+			"method", c.Request().Method,
+			"path", c.Request().URL.Path,
+			"route", route,
+			"status", status,
+			"duration_ms", float64(elapsed.Microseconds())/1000,
+			"remote_ip", c.RealIP(),
+		)
+		return nil
+	}
+}
+
+// *** METRIC TYPES ***
+
+// registry lists the metrics in the order of exposition
+var registry []*metric
+
+// metric is a counter, gauge or histogram with its series per label values
+type metric struct {
+	name   string
+	kind   string // counter, gauge or histogram
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series // by joined label values
+}
+
+type series struct {
+	values []string // label values
+	value  float64  // counter and gauge
+	counts []uint64 // histogram, per bucket (not cumulative)
+	sum    float64  // histogram
+	count  uint64   // histogram
+}
+
+func newMetric(name string, kind string, help string, labels ...string) *metric {
+	m := &metric{name: name, kind: kind, help: help, labels: labels, series: make(map[string]*series)}
+	registry = append(registry, m)
+	return m
+}
+
+// Add adds v to a counter or gauge
+func (m *metric) Add(v float64, values ...string) {
+	m.mu.Lock()
+	m.get(values).value += v
+	m.mu.Unlock()
+}
+
+// Set sets a gauge, or a counter kept elsewhere
+func (m *metric) Set(v float64, values ...string) {
+	m.mu.Lock()
+	m.get(values).value = v
+	m.mu.Unlock()
+}
+
+// Observe records a histogram sample
+func (m *metric) Observe(v float64, values ...string) {
+	i := sort.SearchFloat64s(latencyBuckets, v) // first bucket with v <= bound
+
+	m.mu.Lock()
+	s := m.get(values)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(latencyBuckets)+1) // the last is +Inf
+	}
+	s.counts[i]++
+	s.sum += v
+	s.count++
+	m.mu.Unlock()
+}
+
+// get returns the series of the label values, the caller holds the lock
+func (m *metric) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: values}
+		m.series[key] = s
+	}
+	return s
+}
+
+// write writes the metric in the Prometheus text format
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := m.series[k]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, m.labelSet(s.values, ""), formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelSet(s.values, formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelSet(s.values, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, m.labelSet(s.values, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, m.labelSet(s.values, ""), s.count)
+	}
+}
+
+// labelSet formats the labels of a series, with the le label of a histogram bucket if set
+func (m *metric) labelSet(values []string, le string) string {
+	var pairs []string
+	for i, name := range m.labels {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper escapes a label value like the Prometheus text format: only \, " and newline
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
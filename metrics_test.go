@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestLabelSet(t *testing.T) {
+	m := &metric{labels: []string{"client"}}
+
+	tests := []struct {
+		value, le string
+		want      string
+	}{
+		{"acme", "", `{client="acme"}`},
+		{"Kōrainando", "", `{client="Kōrainando"}`}, // UTF-8 is kept, %q would escape it
+		{`a"b\c` + "\n", "", `{client="a\"b\\c\n"}`},
+		{"tab\there", "0.5", `{client="tab` + "\t" + `here",le="0.5"}`},
+	}
+	for _, tt := range tests {
+		if got := m.labelSet([]string{tt.value}, tt.le); got != tt.want {
+			t.Errorf("labelSet(%q, %q) = %s, want %s", tt.value, tt.le, got, tt.want)
+		}
+	}
+}
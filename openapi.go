@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codcodea/cc/types"
+	"github.com/labstack/echo/v4"
+)
+
+// openapi.go serves the OpenAPI 3.1 document of the API and the JSON Schema of types.Response.
+// The schemas are generated from the Go types by reflection, following their json tags,
+// so the documents cannot drift from the responses. Clients are generated from /v1/openapi.json.
+
+// APIVersion is the path prefix of the current API, the unversioned paths are aliases of it
+const APIVersion = "/v1"
+
+var (
+	specOnce   sync.Once
+	openAPIDoc map[string]any
+	responseJS map[string]any
+)
+
+// HandleOpenAPI GET /v1/openapi.json
+func HandleOpenAPI(c echo.Context) error {
+	specOnce.Do(buildSpecs)
+	return c.JSON(http.StatusOK, openAPIDoc)
+}
+
+// HandleResponseSchema GET /v1/schema/response.json
+// The JSON Schema (2020-12) of the response of GET /v1/colors/:hex
+func HandleResponseSchema(c echo.Context) error {
+	specOnce.Do(buildSpecs)
+	return c.JSON(http.StatusOK, responseJS)
+}
+
+func buildSpecs() {
+	// Standalone JSON Schema of the color response
+	g := newSchemaGen("#/$defs/")
+	responseJS = g.schema(reflect.TypeOf(types.Response{}))
+	responseJS["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	responseJS["$defs"] = g.defs
+
+	// OpenAPI document, its schemas are components
+	g = newSchemaGen("#/components/schemas/")
+	ref := func(v any) map[string]any { return g.schema(reflect.TypeOf(v)) }
+
+	failures := map[string]any{
+		"400": jsonResponse("Invalid input, see the error code", ref(ErrorEnvelope{})),
+		"500": jsonResponse("Server fault", ref(ErrorEnvelope{})),
+		"429": jsonResponse("Rate limited, error code rate_limited, retry after the Retry-After seconds", ref(ErrorEnvelope{})),
+		"503": jsonResponse("The catalogs are loading, error code not_ready", ref(ErrorEnvelope{})),
+	}
+	with := func(ok map[string]any) map[string]any {
+		responses := map[string]any{"200": ok}
+		for k, v := range failures {
+			responses[k] = v
+		}
+		return responses
+	}
+	socket := func(client any, server any) map[string]any {
+		return map[string]any{
+			"101": map[string]any{"description": "Switching to the websocket protocol"},
+			"x-websocket": map[string]any{
+				"client": ref(client),
+				"server": ref(server),
+			},
+		}
+	}
+	lang := param("lang", "query", "Locale of the names, e.g. sv. Falls back to Accept-Language, then en", stringSchema)
+	pantone := param("pantone", "query", "Comma separated Pantone catalogs, currently pms", stringSchema)
+	fields := param("fields", "query", "Comma separated sections to compute: base, names, mono, ral, pan, ncs, conversions. A leading - excludes a section", stringSchema)
+	compact := param("compact", "query", "Answer the flat Compact response", map[string]any{"type": "boolean", "default": false})
+
+	openAPIDoc = map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "cc color API",
+			"version":     strings.TrimPrefix(APIVersion, "/"),
+			"description": "Color names, catalog matches (RAL, Pantone, NCS) and gradients of a color. The unversioned paths are aliases of " + APIVersion + ".",
+		},
+		"paths": map[string]any{
+			APIVersion + "/colors/{hex}": map[string]any{"get": map[string]any{
+				"operationId": "getColor",
+				"summary":     "Names, conversions and catalog matches of a color",
+				"parameters": []any{
+					param("hex", "path", "6 hex digits, without #", map[string]any{"type": "string", "pattern": "^#?[0-9a-fA-F]{6}$"}),
+					pantone, lang, fields, compact,
+				},
+				// anyOf, not oneOf: the variants are open objects and a full Response also matches Partial
+				"responses": with(jsonResponse("The color, a Partial with some ?fields=, a Compact with ?compact=true",
+					map[string]any{"anyOf": []any{ref(types.Response{}), ref(types.Partial{}), ref(types.Compact{})}})),
+			}},
+			APIVersion + "/names/search": map[string]any{"get": map[string]any{
+				"operationId": "searchNames",
+				"summary":     "Ranked name search, with semantic filters like \"warm dark\", \"is:pastel hue:blue\" or \"hue:200-240 chroma:>30\"",
+				"parameters": []any{
+					param("q", "query", "The query", stringSchema),
+					param("limit", "query", "Page size, 1 to 500", map[string]any{"type": "integer", "default": lookupLimit, "minimum": 1, "maximum": maxSearchLimit}),
+					param("offset", "query", "Index of the first result", map[string]any{"type": "integer", "default": 0, "minimum": 0}),
+					lang,
+				},
+				"responses": with(jsonResponse("A page of ranked names", ref(NameSearch{}))),
+			}},
+			APIVersion + "/catalogs": map[string]any{"get": map[string]any{
+				"operationId": "listCatalogs",
+				"summary":     "The loaded catalogs with their source file, checksum, load time and Lab bounds",
+				"responses":   with(jsonResponse("The catalogs in load order", map[string]any{"type": "array", "items": ref(CatalogStatus{})})),
+			}},
+			APIVersion + "/lookup": map[string]any{"get": map[string]any{
+				"operationId": "lookupSocket",
+				"summary":     "Websocket of the name search, see x-websocket for the messages",
+				"parameters":  []any{lang},
+				"responses":   socket(LookupMessage{}, LookupReply{}),
+			}},
+			APIVersion + "/colors/stream": map[string]any{"get": map[string]any{
+				"operationId": "colorStream",
+				"summary":     "Websocket streaming the getColor result of sampled colors, see x-websocket for the messages",
+				"parameters":  []any{pantone, lang, fields, compact},
+				"responses":   socket(ColorSample{}, StreamReply{}),
+			}},
+		},
+		"components": map[string]any{
+			"schemas": g.defs,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+			},
+		},
+		"security": []any{map[string]any{}, map[string]any{"apiKey": []string{}}}, // the key is optional unless -require-api-key
+	}
+}
+
+// *** HELPER FUNCTIONS ***
+
+var stringSchema = map[string]any{"type": "string"}
+
+func param(name string, in string, description string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"name":        name,
+		"in":          in,
+		"required":    in == "path",
+		"description": description,
+		"schema":      schema,
+	}
+}
+
+func jsonResponse(description string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
+
+// schemaGen generates JSON Schemas from Go types, named structs become shared definitions
+type schemaGen struct {
+	prefix string // of the $ref to a definition
+	defs   map[string]any
+}
+
+func newSchemaGen(prefix string) *schemaGen {
+	return &schemaGen{prefix: prefix, defs: make(map[string]any)}
+}
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+// schema returns the schema of t, a $ref for named structs
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t == rawMessageType {
+			return map[string]any{} // any JSON value
+		}
+		s := map[string]any{"type": "array", "items": g.schema(t.Elem())}
+		if t.Kind() == reflect.Array {
+			s["minItems"], s["maxItems"] = t.Len(), t.Len()
+		}
+		return s
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]any{"type": "string", "format": "date-time"}
+		}
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = map[string]any{} // placeholder for recursive types
+			g.defs[t.Name()] = g.object(t)
+		}
+		return map[string]any{"$ref": g.prefix + t.Name()}
+	default:
+		return map[string]any{}
+	}
+}
+
+// object returns the schema of a struct, embedded structs are flattened like encoding/json does
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	g.fields(t, properties, &required, false)
+
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+func (g *schemaGen) fields(t reflect.Type, properties map[string]any, required *[]string, optional bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" {
+			ft, omit := f.Type, optional
+			if ft.Kind() == reflect.Pointer {
+				ft, omit = ft.Elem(), true // a nil embedded pointer omits all its fields
+			}
+			if ft.Kind() == reflect.Struct {
+				g.fields(ft, properties, required, omit)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		schema := g.schema(f.Type)
+		if !optional && !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+			if canBeNull(f.Type) {
+				schema = nullable(schema) // a nil slice, map or pointer is sent as null
+			}
+		}
+		properties[name] = schema
+	}
+}
+
+// canBeNull reports whether encoding/json may encode a value of t as null
+func canBeNull(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Interface:
+		return true
+	case reflect.Slice:
+		return t != rawMessageType // any JSON value already
+	default:
+		return false
+	}
+}
+
+// nullable allows null besides the values of a schema, the OpenAPI 3.1 way
+func nullable(s map[string]any) map[string]any {
+	if t, ok := s["type"].(string); ok {
+		s["type"] = []string{t, "null"}
+		return s
+	}
+	if _, ok := s["$ref"]; ok {
+		return map[string]any{"anyOf": []any{s, map[string]any{"type": "null"}}}
+	}
+	return s // any value
+}
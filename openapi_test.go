@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestColorBodiesMatchSpec(t *testing.T) {
+	loadTestData(t)
+	spec := generatedSpec(t)
+
+	colors := spec["paths"].(map[string]any)["/v1/colors/{hex}"].(map[string]any)["get"].(map[string]any)
+	ok := colors["responses"].(map[string]any)["200"].(map[string]any)
+	response := ok["content"].(map[string]any)["application/json"].(map[string]any)["schema"]
+
+	tests := []struct {
+		name    string
+		opts    Options
+		variant string // the schema the body is meant to match
+	}{
+		{"full", Options{}, "Response"},
+		{"partial", Options{Fields: FieldNames | FieldRAL | FieldPAN}, "Partial"},
+		{"compact", Options{Compact: true}, "Compact"},
+		{"compact partial", Options{Compact: true, Fields: FieldBase | FieldMono}, "Compact"},
+	}
+	for _, tt := range tests {
+		tt.opts.Pantone, tt.opts.Locale = DefaultPantone, DefaultLocale
+		cached, _, err := colorJSON("#3a7bd5", tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var body any
+		if err := json.Unmarshal(cached.Body, &body); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		v := specValidator{spec}
+		if err := v.validate(response, body, "body"); err != nil {
+			t.Errorf("%s: the 200 schema rejects the body: %v", tt.name, err)
+		}
+		if err := v.validate(map[string]any{"$ref": "#/components/schemas/" + tt.variant}, body, "body"); err != nil {
+			t.Errorf("%s: %s rejects the body: %v", tt.name, tt.variant, err)
+		}
+	}
+
+	// The validator must tell the variants apart, a Compact is not a full Response
+	compact, _, _ := colorJSON("#3a7bd5", Options{Pantone: DefaultPantone, Locale: DefaultLocale, Compact: true})
+	var body any
+	json.Unmarshal(compact.Body, &body)
+	if err := (specValidator{spec}).validate(map[string]any{"$ref": "#/components/schemas/Response"}, body, "body"); err == nil {
+		t.Error("Response accepts a Compact body")
+	}
+}
+
+// generatedSpec returns the OpenAPI document as decoded JSON, the form clients see
+func generatedSpec(t *testing.T) map[string]any {
+	t.Helper()
+	specOnce.Do(buildSpecs)
+	data, err := json.Marshal(openAPIDoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec map[string]any
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+// specValidator checks a decoded JSON value against the subset of JSON Schema emitted by schemaGen
+type specValidator struct {
+	doc map[string]any
+}
+
+func (v specValidator) validate(schema any, value any, at string) error {
+	s, _ := schema.(map[string]any)
+
+	if ref, ok := s["$ref"].(string); ok {
+		target := any(v.doc)
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			target = target.(map[string]any)[part]
+		}
+		return v.validate(target, value, at)
+	}
+	if anyOf, ok := s["anyOf"].([]any); ok {
+		errs := []string{}
+		for _, sub := range anyOf {
+			err := v.validate(sub, value, at)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("%s matches no anyOf schema: %s", at, strings.Join(errs, "; "))
+	}
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		matched := 0
+		for _, sub := range oneOf {
+			if v.validate(sub, value, at) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s matches %d oneOf schemas", at, matched)
+		}
+	}
+
+	if typ, ok := s["type"]; ok {
+		types := []any{typ}
+		if list, ok := typ.([]any); ok {
+			types = list
+		}
+		if !hasJSONType(types, value) {
+			return fmt.Errorf("%s: %T is not of type %v", at, value, typ)
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := s["properties"].(map[string]any)
+		required, _ := s["required"].([]any)
+		for _, name := range required {
+			if _, ok := value[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required %s", at, name)
+			}
+		}
+		for name, field := range value {
+			sub, ok := properties[name]
+			if !ok {
+				sub, ok = s["additionalProperties"]
+			}
+			if !ok {
+				continue
+			}
+			if sub == false {
+				return fmt.Errorf("%s: unexpected property %s", at, name)
+			}
+			if err := v.validate(sub, field, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if n, ok := s["minItems"].(float64); ok && len(value) < int(n) {
+			return fmt.Errorf("%s: %d items, want at least %v", at, len(value), n)
+		}
+		if n, ok := s["maxItems"].(float64); ok && len(value) > int(n) {
+			return fmt.Errorf("%s: %d items, want at most %v", at, len(value), n)
+		}
+		for i, item := range value {
+			if err := v.validate(s["items"], item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hasJSONType reports whether a decoded JSON value is of one of the types
+func hasJSONType(types []any, value any) bool {
+	for _, typ := range types {
+		switch value := value.(type) {
+		case nil:
+			if typ == "null" {
+				return true
+			}
+		case bool:
+			if typ == "boolean" {
+				return true
+			}
+		case float64:
+			if typ == "number" || typ == "integer" && value == float64(int64(value)) {
+				return true
+			}
+		case string:
+			if typ == "string" {
+				return true
+			}
+		case []any:
+			if typ == "array" {
+				return true
+			}
+		case map[string]any:
+			if typ == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package palette
+
+import (
+	"strings"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Describe generates a descriptive color name from the LCh coordinates of a color,
+// e.g. "dark desaturated blue-green", in the style of the ISCC–NBS color designations.
+// Unlike the nearest-name lookup the result only uses a small controlled vocabulary,
+// which reads well in formal briefs:
+// - a lightness and chroma modifier: very pale, light, desaturated, strong, vivid, deep, ...
+// - a hue family: red, orange, yellow, ..., or brown, olive and pink for the darker and lighter variants
+// - gray shades for near neutral colors: white, light gray, bluish gray, black, ...
+
+// All thresholds use the Lab/LCh scale of go-colorful (L 0-1, chroma about 0-1.3).
+
+// hueFamily is a hue range starting at From degrees (LCh hue) up to the next entry
+type hueFamily struct {
+	From  float64
+	Name  string
+	Tinge string // adjective for near neutral colors, e.g. "reddish gray"
+}
+
+var hueFamilies = []hueFamily{
+	{0, "purple-red", "reddish"},
+	{15, "red", "reddish"},
+	{50, "orange", "brownish"},
+	{70, "yellow-orange", "brownish"},
+	{90, "yellow", "yellowish"},
+	{112, "yellow-green", "greenish"},
+	{128, "green", "greenish"},
+	{160, "blue-green", "bluish"},
+	{215, "green-blue", "bluish"},
+	{250, "blue", "bluish"},
+	{310, "violet", "purplish"},
+	{322, "purple", "purplish"},
+	{345, "purple-red", "reddish"},
+}
+
+// Lightness and chroma bands, upper bounds
+var (
+	lightnessBands = []float64{0.22, 0.42, 0.65, 0.82}       // very dark, dark, medium, light, (very light)
+	chromaBands    = []float64{0.2, 0.45, 0.75}              // weak, moderate, strong, (vivid)
+	grayBands      = []float64{0.12, 0.25, 0.45, 0.75, 0.93} // black, very dark, dark, medium, light, (white)
+)
+
+// modifiers[lightness][chroma]
+var modifiers = [5][4]string{
+	{"blackish", "very dark", "very deep", "very deep"},  // very dark
+	{"dark desaturated", "dark", "deep", "vivid"},        // dark
+	{"desaturated", "moderate", "strong", "vivid"},       // medium
+	{"light desaturated", "light", "brilliant", "vivid"}, // light
+	{"very pale", "pale", "brilliant", "vivid"},          // very light
+}
+
+var grays = []string{"black", "very dark gray", "dark gray", "gray", "light gray", "white"}
+
+const (
+	neutralChroma = 0.04 // below: pure gray
+	tingedChroma  = 0.09 // below: tinged gray, e.g. "bluish gray"
+)
+
+// Describe returns the descriptive name of c, see above
+func Describe(c colorful.Color) string {
+	h, chroma, l := c.Clamped().Hcl()
+
+	if chroma < tingedChroma {
+		gray := grays[band(l, grayBands)]
+		if chroma < neutralChroma {
+			return gray
+		}
+		tinge := family(h).Tinge
+		if gray == "gray" || gray == "white" || gray == "black" {
+			return tinge + " " + gray
+		}
+		// "light gray" -> "light bluish gray"
+		i := strings.LastIndex(gray, " ")
+		return gray[:i] + " " + tinge + gray[i:]
+	}
+
+	li := band(l, lightnessBands)
+	ci := band(chroma, chromaBands)
+
+	name := family(h).Name
+
+	// Dark oranges and yellows read as browns and olives, light reds as pinks
+	switch {
+	case h >= 15 && h < 90 && l < 0.45 && chroma < 0.7:
+		name = "brown"
+		if h < 40 {
+			name = "reddish brown"
+		}
+	case h >= 90 && h < 112 && l < 0.6:
+		name = "olive"
+	case h >= 112 && h < 128 && l < 0.5:
+		name = "olive green"
+	case (h >= 345 || h < 50) && l >= 0.65 && chroma < 0.6:
+		name = "pink"
+	}
+
+	return modifiers[li][ci] + " " + name
+}
+
+// band returns the index of the first band with v below its upper bound
+func band(v float64, bounds []float64) int {
+	for i, b := range bounds {
+		if v < b {
+			return i
+		}
+	}
+	return len(bounds)
+}
+
+// family returns the hue family of a hue angle in degrees
+func family(h float64) hueFamily {
+	f := hueFamilies[0]
+	for _, hf := range hueFamilies {
+		if h >= hf.From {
+			f = hf
+		}
+	}
+	return f
+}
+
+// HueRange returns the hue range in degrees of a hue family, e.g. "blue" -> 250, 310.
+// The range of "purple-red" wraps around 0, from is then larger than to.
+func HueRange(name string) (from float64, to float64, ok bool) {
+	for i, hf := range hueFamilies {
+		if hf.Name != name || (i == 0 && hueFamilies[len(hueFamilies)-1].Name == name) {
+			continue
+		}
+		from, to = hf.From, 360
+		if i+1 < len(hueFamilies) {
+			to = hueFamilies[i+1].From
+		} else if hueFamilies[0].Name == name {
+			to = hueFamilies[1].From
+		}
+		return from, to, true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codcodea/cc/palette"
+)
+
+// query.go holds the semantic name query: names are filtered by their LCh coordinates, not just their text.
+// A query is a list of space separated terms:
+// - filters on a coordinate: hue:200-240, chroma:>30, lightness:<=40, h:15 (a single value allows ±slack)
+// - explicit keywords and hue families: is:pastel, hue:blue
+// - keywords standing for a set of filters: warm, cool, pastel, pale, muted, vivid, bright, dark, light, deep, neutral
+// - hue families, e.g. blue or yellow-green, see palette.HueRange
+// - any other word is matched against the name text, like a plain search (see ColorLookUp)
+// The words are always matched as a name first, so "light blue" finds Light Blue and "dark" the names with "dark".
+// Only when no name matches them without typos, the keywords and hue families turn into filters:
+// "warm dark" lists the warm dark colors and "pastel sage" the pastel names containing "sage".
+// A hue family is a filter only next to a keyword or a filter, "blue" alone is always text.
+
+// The coordinates use the CSS LCh scale: lightness 0-100, chroma 0-150, hue 0-360 degrees.
+
+// Coordinates of a filter
+const (
+	AttrLightness = iota
+	AttrChroma
+	AttrHue
+)
+
+var attrNames = map[string]int{
+	"l": AttrLightness, "lightness": AttrLightness, "light": AttrLightness,
+	"c": AttrChroma, "chroma": AttrChroma,
+	"h": AttrHue, "hue": AttrHue,
+}
+
+// scale is the largest value per coordinate, an open range ends there
+var scale = [3]float64{100, 150, 360}
+
+// slack is the tolerance of a single value filter per coordinate, e.g. hue:200 is hue:190-210
+var slack = [3]float64{5, 5, 10}
+
+// hueChroma is the chroma below which a color has no meaningful hue, hue filters reject it
+const hueChroma = 5
+
+// Filter restricts a coordinate to Min..Max, both inclusive.
+// A hue range with Min > Max wraps around 0, e.g. 340-20.
+type Filter struct {
+	Attr int
+	Min  float64
+	Max  float64
+}
+
+// keywords maps a semantic word to its filters
+var keywords = map[string][]Filter{
+	"warm":    {{AttrHue, 330, 100}, {AttrChroma, 10, 150}},
+	"cool":    {{AttrHue, 150, 300}, {AttrChroma, 10, 150}},
+	"pastel":  {{AttrLightness, 75, 100}, {AttrChroma, 10, 45}},
+	"pale":    {{AttrLightness, 80, 100}, {AttrChroma, 0, 25}},
+	"muted":   {{AttrChroma, 5, 30}},
+	"vivid":   {{AttrChroma, 60, 150}},
+	"bright":  {{AttrLightness, 55, 100}, {AttrChroma, 50, 150}},
+	"dark":    {{AttrLightness, 0, 40}},
+	"light":   {{AttrLightness, 70, 100}},
+	"deep":    {{AttrLightness, 0, 45}, {AttrChroma, 40, 150}},
+	"neutral": {{AttrChroma, 0, 8}},
+}
+
+// Query is a parsed name query
+type Query struct {
+	Text     string   // normalized words, matched against the names first
+	Filters  []Filter // explicit filters, all must match
+	Keywords []Filter // filters of the keywords and hue families, used when no name matches Text
+	Rest     string   // the words of Text that are not keywords, matched along with Keywords
+}
+
+// ParseQuery parses a normalized query, see above
+func ParseQuery(query string) (Query, error) {
+	terms := strings.Fields(query)
+
+	q := Query{}
+	var text, rest []string
+	var hues []Filter
+	keyword := false
+
+	for _, term := range terms {
+		if attr, value, ok := strings.Cut(term, ":"); ok {
+			filters, err := parseTerm(attr, value)
+			if err != nil {
+				return Query{}, err
+			}
+			q.Filters = append(q.Filters, filters...)
+			continue
+		}
+
+		text = append(text, term)
+		if filters, ok := keywords[term]; ok {
+			q.Keywords = append(q.Keywords, filters...)
+			keyword = true
+			continue
+		}
+		if from, to, ok := palette.HueRange(term); ok {
+			hues = append(hues, Filter{AttrHue, from, to})
+		}
+		rest = append(rest, term)
+	}
+
+	// Hue families filter only next to a keyword or a filter, the words stay text otherwise
+	if keyword || len(q.Filters) > 0 {
+		q.Keywords = append(q.Keywords, hues...)
+		rest = slices.DeleteFunc(rest, func(w string) bool {
+			_, _, ok := palette.HueRange(w)
+			return ok
+		})
+	}
+	if len(q.Keywords) > 0 {
+		q.Rest = strings.Join(rest, " ")
+	}
+
+	q.Text = strings.Join(text, " ")
+	return q, nil
+}
+
+// Match reports whether the LCh coordinates pass all filters
+func (q Query) Match(lch [3]float32) bool {
+	for _, f := range q.Filters {
+		v := float64(lch[f.Attr])
+
+		if f.Attr == AttrHue {
+			if float64(lch[AttrChroma]) < hueChroma {
+				return false
+			}
+			if f.Min > f.Max {
+				if v < f.Min && v > f.Max {
+					return false
+				}
+				continue
+			}
+		}
+		if v < f.Min || v > f.Max {
+			return false
+		}
+	}
+	return true
+}
+
+// Query returns the names matching a parsed query, best first:
+// - the text is matched against the names like Search, exact and phrase matches first
+// - when no name matches the text without typos, the keywords filter and the other words are matched instead
+// - a query of filters only ranks the names by their distance to the middle of the filter ranges
+// It stops with the error of ctx once ctx ends, e.g. when the client cancelled the search.
+func (x *NameIndex) Query(ctx context.Context, q Query) ([]NameMatch, error) {
+	if q.Text != "" {
+		matches := x.filter(x.Search(q.Text), q)
+		if len(q.Keywords) == 0 || (len(matches) > 0 && matches[0].Tier < TierFuzzy) {
+			return matches, ctx.Err()
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	semantic := Query{Filters: append(slices.Clone(q.Filters), q.Keywords...)}
+	if q.Rest != "" {
+		return x.filter(x.Search(q.Rest), semantic), ctx.Err()
+	}
+	return x.rankFiltered(ctx, semantic)
+}
+
+// *** HELPER FUNCTIONS ***
+
+// filter keeps the matches passing the filters of q, in order
+func (x *NameIndex) filter(matches []NameMatch, q Query) []NameMatch {
+	out := matches[:0]
+	for _, m := range matches {
+		if q.Match(x.lch[m.Index]) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// rankFiltered returns the names passing the filters of q, nearest to the middle of the filter ranges first.
+// Ties are broken by good names first and then the catalog order, like sortMatches.
+func (x *NameIndex) rankFiltered(ctx context.Context, q Query) ([]NameMatch, error) {
+	matches := []NameMatch{}
+	scores := []float64{}
+	for i, lch := range x.lch {
+		if i%4096 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if q.Match(lch) {
+			matches = append(matches, NameMatch{Index: i})
+			scores = append(scores, filterDistance(q.Filters, lch))
+		}
+	}
+
+	order := make([]int, len(matches))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if scores[a] != scores[b] {
+			return scores[a] < scores[b]
+		}
+		na, nb := x.names[matches[a].Index], x.names[matches[b].Index]
+		return na.Good && !nb.Good
+	})
+
+	ranked := make([]NameMatch, len(matches))
+	for i, o := range order {
+		ranked[i] = matches[o]
+	}
+	return ranked, ctx.Err()
+}
+
+// filterDistance sums the distances of the coordinates to the middle of their filter ranges,
+// each relative to the width of the range
+func filterDistance(filters []Filter, lch [3]float32) float64 {
+	d := 0.0
+	for _, f := range filters {
+		v := float64(lch[f.Attr])
+		lo, hi := f.Min, math.Min(f.Max, scale[f.Attr])
+
+		if f.Attr == AttrHue {
+			if lo > hi {
+				hi += 360 // wraps around 0
+			}
+			mid := wrapHue((lo + hi) / 2)
+			diff := math.Abs(v - mid)
+			d += math.Min(diff, 360-diff) / math.Max(hi-lo, 1)
+			continue
+		}
+		d += math.Abs(v-(lo+hi)/2) / math.Max(hi-lo, 1)
+	}
+	return d
+}
+
+// parseTerm parses an explicit term: a filter, is:<keyword> or hue:<family>
+func parseTerm(attr string, value string) ([]Filter, error) {
+	if attr == "is" {
+		filters, ok := keywords[value]
+		if !ok {
+			return nil, fmt.Errorf("unknown keyword %q, use warm, cool, pastel, pale, muted, vivid, bright, dark, light, deep or neutral", value)
+		}
+		return filters, nil
+	}
+	if a, ok := attrNames[attr]; ok && a == AttrHue {
+		if from, to, ok := palette.HueRange(value); ok {
+			return []Filter{{AttrHue, from, to}}, nil
+		}
+	}
+
+	f, err := parseFilter(attr, value)
+	if err != nil {
+		return nil, err
+	}
+	return []Filter{f}, nil
+}
+
+// parseFilter parses the range of a filter term: a-b, >a, >=a, <a, <=a or a
+func parseFilter(name string, value string) (Filter, error) {
+	attr, ok := attrNames[name]
+	if !ok {
+		return Filter{}, fmt.Errorf("unknown filter %q, use hue, chroma or lightness", name)
+	}
+	f := Filter{Attr: attr, Min: 0, Max: 360}
+
+	var err error
+	switch {
+	case strings.HasPrefix(value, ">="):
+		f.Min, err = parseValue(value[2:])
+	case strings.HasPrefix(value, ">"):
+		f.Min, err = parseValue(value[1:])
+		f.Min = math.Nextafter(f.Min, math.Inf(1))
+	case strings.HasPrefix(value, "<="):
+		f.Max, err = parseValue(value[2:])
+	case strings.HasPrefix(value, "<"):
+		f.Max, err = parseValue(value[1:])
+		f.Max = math.Nextafter(f.Max, math.Inf(-1))
+	case strings.Contains(value, "-"):
+		lo, hi, _ := strings.Cut(value, "-")
+		if f.Min, err = parseValue(lo); err == nil {
+			f.Max, err = parseValue(hi)
+		}
+		if err == nil && f.Min > f.Max && attr != AttrHue {
+			err = fmt.Errorf("empty range %q", value)
+		}
+	default:
+		var v float64
+		v, err = parseValue(value)
+		f.Min, f.Max = v-slack[attr], v+slack[attr]
+		if attr == AttrHue {
+			f.Min, f.Max = wrapHue(f.Min), wrapHue(f.Max)
+		}
+	}
+	if err != nil {
+		return Filter{}, fmt.Errorf("bad filter %s:%s: %w", name, value, err)
+	}
+	return f, nil
+}
+
+func parseValue(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 || v > 360 {
+		return 0, fmt.Errorf("expected a number from 0 to 360, got %q", s)
+	}
+	return v, nil
+}
+
+// wrapHue maps a hue angle into 0-360
+func wrapHue(h float64) float64 {
+	if h < 0 {
+		return h + 360
+	}
+	if h >= 360 {
+		return h - 360
+	}
+	return h
+}
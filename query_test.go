@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/codcodea/cc/palette"
+)
+
+func hueFilter(family string) Filter {
+	from, to, _ := palette.HueRange(family)
+	return Filter{AttrHue, from, to}
+}
+
+func TestParseQuery(t *testing.T) {
+	blue := hueFilter("blue")
+
+	tests := []struct {
+		query string
+		want  Query
+	}{
+		{"blue", Query{Text: "blue"}},
+		{"deep sky blue", Query{Text: "deep sky blue", Keywords: append(keywords["deep"], blue), Rest: "sky"}},
+		{"light blue", Query{Text: "light blue", Keywords: append(keywords["light"], blue)}},
+		{"pastel sage", Query{Text: "pastel sage", Keywords: keywords["pastel"], Rest: "sage"}},
+		{"warm dark", Query{Text: "warm dark", Keywords: append(keywords["warm"], keywords["dark"]...)}},
+		{"hue:200-240 chroma:>30", Query{Filters: []Filter{{AttrHue, 200, 240}, {AttrChroma, math.Nextafter(30, 31), 360}}}},
+		{"h:355", Query{Filters: []Filter{{AttrHue, 345, 5}}}},
+		{"l:<=40 navy", Query{Text: "navy", Filters: []Filter{{AttrLightness, 0, 40}}}},
+		{"is:pastel hue:blue", Query{Filters: append(keywords["pastel"], blue)}},
+		{"blue c:>50", Query{Text: "blue", Filters: []Filter{{AttrChroma, math.Nextafter(50, 51), 360}}, Keywords: []Filter{blue}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{"size:3", "is:shiny", "lightness:50-20", "hue:abc", "chroma:400"} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("ParseQuery(%q): no error", query)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	tests := []struct {
+		query string
+		lch   [3]float32
+		want  bool
+	}{
+		{"blue", [3]float32{50, 0, 0}, true}, // text only
+		{"hue:200-240", [3]float32{50, 40, 220}, true},
+		{"hue:200-240", [3]float32{50, 40, 250}, false},
+		{"hue:200-240", [3]float32{50, 2, 220}, false}, // grey, no hue
+		{"h:355", [3]float32{50, 40, 2}, true},         // wraps around 0
+		{"h:355", [3]float32{50, 40, 10}, false},
+		{"chroma:>30", [3]float32{50, 30, 0}, false},
+		{"chroma:>=30", [3]float32{50, 30, 0}, true},
+		{"l:<40 c:10-20", [3]float32{39, 15, 0}, true},
+		{"l:<40 c:10-20", [3]float32{40, 15, 0}, false},
+		{"is:dark", [3]float32{30, 0, 0}, true},
+		{"is:pastel", [3]float32{85, 50, 0}, false},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := q.Match(tt.lch); got != tt.want {
+			t.Errorf("ParseQuery(%q).Match(%v) = %v, want %v", tt.query, tt.lch, got, tt.want)
+		}
+	}
+}
+
+func TestNameIndexQuery(t *testing.T) {
+	loadTestData(t)
+	index, names := Indexes[DefaultLocale], Names[DefaultLocale]
+
+	// The name matching the words comes first, even if they are keywords
+	for query, want := range map[string]string{
+		"light blue":    "Light Blue",
+		"deep sky blue": "Deep Sky Blue",
+		"dark olive":    "Dark Olive",
+		"dark":          "Dark",
+		"pastel blue":   "Pastel Blue",
+	} {
+		q, _ := ParseQuery(query)
+		matches, _ := index.Query(context.Background(), q)
+		if len(matches) == 0 || names[matches[0].Index].Name != want {
+			t.Errorf("%q: want %s first, got %d matches", query, want, len(matches))
+		}
+	}
+
+	// Without a name, the keywords filter and the result is ranked
+	for _, query := range []string{"warm dark", "is:pastel hue:blue", "hue:200-240 chroma:>30"} {
+		q, _ := ParseQuery(query)
+		semantic := Query{Filters: append(q.Filters, q.Keywords...)}
+
+		matches, err := index.Query(context.Background(), q)
+		if len(matches) == 0 || err != nil {
+			t.Errorf("%q: no matches, %v", query, err)
+		}
+		last := -1.0
+		for _, m := range matches {
+			lch := index.lch[m.Index]
+			if !semantic.Match(lch) {
+				t.Fatalf("%q: %s does not pass the filters", query, names[m.Index].Name)
+			}
+			d := filterDistance(semantic.Filters, lch)
+			if d < last {
+				t.Fatalf("%q: %s is out of order", query, names[m.Index].Name)
+			}
+			last = d
+		}
+	}
+}
+
+func TestNameIndexQueryCancelled(t *testing.T) {
+	loadTestData(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, query := range []string{"blue", "warm dark", "is:pastel"} {
+		q, _ := ParseQuery(query)
+		if _, err := Indexes[DefaultLocale].Query(ctx, q); !errors.Is(err, context.Canceled) {
+			t.Errorf("%q: got %v, want context.Canceled", query, err)
+		}
+	}
+}
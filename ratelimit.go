@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+// ratelimit.go holds the rate limiting and the API keys of the API.
+// Every client has a token bucket, keyed by its API key or else its IP:
+// - a request takes tokens proportional to its work, see the CostFunc of each route in main.go
+// - a websocket session takes a token to connect and one per client message, from the same bucket (Socket.Receive),
+//   the color stream one per sample it processes (Socket.Charge)
+// - an empty bucket answers 429 rate_limited with Retry-After, the seconds until the tokens are back
+// - the API key is sent in the X-API-Key header, or ?api_key= where headers can't be set (websockets)
+// - the keys are read from a JSON file (-api-keys), each with its own quota and allowed origins:
+//
+//	[{"key":"3f9c…","name":"acme","rate":50,"burst":100,"origins":["https://acme.com"]}]
+//
+// Without a key the client gets the anonymous quota (-rate-limit, -rate-burst), unless -require-api-key is set.
+// Its IP is the address of the connection, or the X-Forwarded-For of the proxies of -trusted-proxies (Config.IPExtractor).
+
+// APIKey is an API key of the keys file
+type APIKey struct {
+	Key     string   `json:"key"`
+	Name    string   `json:"name"`              // identifies the client in logs and metrics, never the key itself
+	Rate    float64  `json:"rate,omitempty"`    // tokens per second, the anonymous rate if 0
+	Burst   int      `json:"burst,omitempty"`   // bucket size, the anonymous burst if 0
+	Origins []string `json:"origins,omitempty"` // allowed Origin headers, any if empty
+}
+
+// CostFunc returns the tokens a request takes
+type CostFunc func(c echo.Context) int
+
+// Quota is the bucket of an authenticated client, kept by its websocket sessions to charge every message
+type Quota struct {
+	limiter *RateLimiter
+	key     *APIKey
+	client  string // bucket, key:<name> or ip:<address>
+}
+
+// quotaKey is the echo context key of the *Quota of a limited request
+const quotaKey = "quota"
+
+// RateLimiter is the set of token buckets of the clients
+type RateLimiter struct {
+	anonymous  APIKey             // quota of the clients without a key
+	keys       map[string]*APIKey // by key
+	requireKey bool
+
+	mu        sync.Mutex
+	buckets   map[string]*rate.Limiter // by client, key:<name> or ip:<address>
+	lastSweep time.Time
+}
+
+// apiKeyHeader carries the API key
+const apiKeyHeader = "X-API-Key"
+
+// sweepInterval is how often full buckets, of idle clients, are dropped
+const sweepInterval = time.Minute
+
+var rateLimited = newMetric("cc_rate_limited_total", "counter",
+	"Requests rejected by the rate limiter, by client (the key name, or anonymous).", "client")
+
+// NewRateLimiter creates the limiter of cfg, reading its keys file if set
+func NewRateLimiter(cfg Config) (*RateLimiter, error) {
+	l := &RateLimiter{
+		anonymous:  APIKey{Name: "anonymous", Rate: cfg.RateLimit, Burst: cfg.RateBurst},
+		keys:       make(map[string]*APIKey),
+		requireKey: cfg.RequireAPIKey,
+		buckets:    make(map[string]*rate.Limiter),
+		lastSweep:  time.Now(),
+	}
+	if cfg.APIKeysFile == "" {
+		if cfg.RequireAPIKey {
+			return nil, errors.New("require-api-key needs an api-keys file")
+		}
+		return l, nil
+	}
+
+	data, err := os.ReadFile(cfg.APIKeysFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading API keys: %w", err)
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parsing API keys %s: %w", cfg.APIKeysFile, err)
+	}
+
+	names := make(map[string]bool)
+	for i := range keys {
+		k := &keys[i]
+		switch {
+		case k.Key == "":
+			return nil, fmt.Errorf("API key %d has no key", i)
+		case k.Name == "":
+			return nil, fmt.Errorf("API key %d has no name", i)
+		case l.keys[k.Key] != nil || names[k.Name]:
+			return nil, fmt.Errorf("API key %s is listed twice", k.Name)
+		case k.Rate < 0 || k.Burst < 0:
+			return nil, fmt.Errorf("API key %s has a negative quota", k.Name)
+		}
+		if k.Rate == 0 {
+			k.Rate = l.anonymous.Rate
+		}
+		if k.Burst == 0 {
+			k.Burst = l.anonymous.Burst
+		}
+		l.keys[k.Key] = k
+		names[k.Name] = true
+	}
+	return l, nil
+}
+
+// Limit is the middleware authenticating the client and taking the cost of the request from its bucket
+func (l *RateLimiter) Limit(cost CostFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key, err := l.authenticate(c)
+			if err != nil {
+				return err
+			}
+			if key.Rate == 0 {
+				return next(c) // not limited
+			}
+
+			// A request larger than the bucket takes it all, rather than never passing
+			n := min(max(cost(c), 1), key.Burst)
+
+			quota := &Quota{limiter: l, key: key, client: l.client(c, key)}
+			remaining, retry, ok := quota.take(n, time.Now())
+			if !ok {
+				c.Response().Header().Set("Retry-After", strconv.Itoa(retry))
+				return quota.exceeded(n, retry)
+			}
+
+			h := c.Response().Header()
+			h.Set("X-RateLimit-Limit", strconv.Itoa(key.Burst))
+			h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			c.Set(quotaKey, quota)
+			return next(c)
+		}
+	}
+}
+
+// UnitCost is the cost of a request doing a single lookup
+func UnitCost(c echo.Context) int {
+	return 1
+}
+
+// Take takes the token of a websocket message, the error answers a message over the quota
+func (q *Quota) Take() *APIError {
+	if _, retry, ok := q.take(1, time.Now()); !ok {
+		return q.exceeded(1, retry)
+	}
+	return nil
+}
+
+// *** HELPER FUNCTIONS ***
+
+// authenticate returns the quota of the client, an unknown key or a disallowed origin is rejected
+func (l *RateLimiter) authenticate(c echo.Context) (*APIKey, error) {
+	token := c.Request().Header.Get(apiKeyHeader)
+	if token == "" {
+		token = c.QueryParam("api_key")
+	}
+
+	if token == "" {
+		if l.requireKey {
+			return nil, NewError(http.StatusUnauthorized, ErrInvalidAPIKey, "an API key is required", "",
+				"send the key in the "+apiKeyHeader+" header, or ?api_key= for websockets")
+		}
+		return &l.anonymous, nil
+	}
+
+	key, ok := l.keys[token]
+	if !ok {
+		return nil, NewError(http.StatusUnauthorized, ErrInvalidAPIKey, "unknown API key", "", "") // the key is not echoed
+	}
+
+	if origin := c.Request().Header.Get(echo.HeaderOrigin); origin != "" && len(key.Origins) > 0 {
+		allowed := false
+		for _, o := range key.Origins {
+			allowed = allowed || o == origin
+		}
+		if !allowed {
+			return nil, NewError(http.StatusForbidden, ErrOriginDenied, "origin not allowed for this API key", origin, "")
+		}
+	}
+	return key, nil
+}
+
+// client returns the bucket name of a client, the clients without a key are told apart by IP
+func (l *RateLimiter) client(c echo.Context, key *APIKey) string {
+	if key == &l.anonymous {
+		return "ip:" + c.RealIP()
+	}
+	return "key:" + key.Name
+}
+
+// take takes n tokens, or returns the seconds until they are back.
+// The bucket is looked up each time, a sweep may have dropped it since the last take.
+func (q *Quota) take(n int, now time.Time) (remaining int, retry int, ok bool) {
+	bucket := q.limiter.bucket(q.client, q.key, now)
+	r := bucket.ReserveN(now, n)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		rateLimited.Add(1, q.key.Name)
+		return 0, int(math.Ceil(delay.Seconds())), false
+	}
+	return int(bucket.TokensAt(now)), 0, true
+}
+
+// exceeded is the error of a request of n tokens over the quota
+func (q *Quota) exceeded(n int, retry int) *APIError {
+	return NewError(http.StatusTooManyRequests, ErrRateLimited,
+		fmt.Sprintf("rate limit of %s exceeded, the request costs %d of %d tokens", q.key.Name, n, q.key.Burst),
+		"", fmt.Sprintf("retry in %ds", retry))
+}
+
+// bucket returns the token bucket of a client
+func (l *RateLimiter) bucket(client string, key *APIKey, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		for k, b := range l.buckets {
+			if b.TokensAt(now) >= float64(b.Burst()) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = rate.NewLimiter(rate.Limit(key.Rate), key.Burst)
+		l.buckets[client] = b
+	}
+	return b
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRateLimitClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		remote  string // address of the connection
+		forward []string
+		codes   []int
+	}{
+		// A client can't get a fresh bucket by changing X-Forwarded-For
+		{"spoofed", nil, "203.0.113.7:4000", []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"},
+			[]int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+		// Nor is a private address trusted by default
+		{"private", nil, "10.0.0.1:4000", []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"},
+			[]int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+		// Behind a trusted proxy the clients are told apart by X-Forwarded-For
+		{"trusted proxy", []string{"10.0.0.0/8"}, "10.0.0.1:4000", []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"},
+			[]int{http.StatusOK, http.StatusOK, http.StatusOK}},
+		{"untrusted proxy", []string{"10.0.0.1"}, "10.0.0.2:4000", []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"},
+			[]int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.RateLimit, cfg.RateBurst = 0.001, 2
+		cfg.TrustedProxies = tt.proxies
+		if err := cfg.validate(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		limiter, err := NewRateLimiter(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		app := echo.New()
+		app.HTTPErrorHandler = HandleError
+		app.IPExtractor = cfg.IPExtractor()
+		app.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, limiter.Limit(UnitCost))
+
+		for i, ip := range tt.forward {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			req.Header.Set(echo.HeaderXForwardedFor, ip)
+			rec := httptest.NewRecorder()
+			app.ServeHTTP(rec, req)
+			if rec.Code != tt.codes[i] {
+				t.Errorf("%s: request %d from %s: %d, want %d", tt.name, i+1, ip, rec.Code, tt.codes[i])
+			}
+		}
+	}
+
+	bad := DefaultConfig()
+	bad.TrustedProxies = []string{"10.0.0.0/33"}
+	if bad.validate() == nil {
+		t.Error("bad trusted proxy accepted")
+	}
+}
@@ -1,42 +1,133 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 
 	pk "github.com/codcodea/cc/db"
+	"github.com/codcodea/cc/palette"
 	"github.com/codcodea/cc/types"
 	"github.com/labstack/echo/v4"
+	"github.com/lucasb-eyer/go-colorful"
 	"golang.org/x/net/websocket"
 )
 
 // HandleRoot GET /
 func HandleRoot(c echo.Context) error {
-	return c.String(http.StatusOK, "Color backend API is live!\nTry /colors/<hex> without '#', e.g /colors/ff0000")
+	return c.String(http.StatusOK, "Color backend API is live!\nTry /v1/colors/<hex> without '#', e.g /v1/colors/ff0000\nThe API is described in /v1/openapi.json")
 }
 
-// HandleColor GET /colors/:hex
+// HandleColor GET /v1/colors/:hex
+// Optional query ?pantone=pms selects the Pantone sub-catalogs to match
+// Optional query ?lang=sv (or the Accept-Language header) selects the locale of the names
+// Optional query ?fields=names,ral (or ?fields=-mono) and ?compact=true shape the response, see fields.go
 func HandleColor(c echo.Context) error {
-	color := "#" + c.Param("hex")
-	if len(color) != 7 {
-		return c.String(http.StatusBadRequest, "Bad request")
+	color, ok := normalizeHex(c.Param("hex"))
+	if !ok {
+		return InvalidColor(c.Param("hex"))
 	}
 
-	jsonData, err := getColor(color)
+	opts, err := colorOptions(c)
 	if err != nil {
-		return c.String(http.StatusBadRequest, "Bad request")
+		return err
+	}
+
+	// The color is valid, a failure is a server fault
+	cached, hit, err := colorJSON(color, opts)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	// The response only depends on the URL, the locale negotiated from Accept-Language and the catalogs
+	h := c.Response().Header()
+	h.Set("ETag", cached.ETag)
+	h.Set("Cache-Control", colorCacheControl)
+	h.Add("Vary", "Accept-Language")
+	if hit {
+		h.Set("X-Cache", "HIT")
+	} else {
+		h.Set("X-Cache", "MISS")
+	}
+
+	if match := c.Request().Header.Get("If-None-Match"); match != "" && etagMatch(match, cached.ETag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSONBlob(http.StatusOK, cached.Body)
+}
+
+// colorCacheControl lets browsers and CDNs keep a color response for a day, the catalogs rarely change
+const colorCacheControl = "public, max-age=86400"
+
+// etagMatch reports whether an If-None-Match header lists the etag
+func etagMatch(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Options holds the per-request settings for getColor
+type Options struct {
+	Pantone []string // Pantone sub-catalogs to match, see PantoneCatalogs
+	Locale  string   // locale of the color names, see ResolveLocale
+	Fields  Fields   // sections to compute, 0 is all, see fields.go
+	Compact bool     // answer a types.Compact, see renderColor
+}
+
+// colorOptions parses the query options of the color routes
+func colorOptions(c echo.Context) (Options, error) {
+	pantone, err := ParsePantone(c.QueryParam("pantone"))
+	if err != nil {
+		return Options{}, pantoneError(c, err)
+	}
+
+	fields, err := ParseFields(c.QueryParam("fields"))
+	if err != nil {
+		return Options{}, InvalidParam("fields", c.QueryParam("fields"), err,
+			"a comma separated list of base, names, mono, ral, pan, ncs, conversions, a leading - excludes")
 	}
-	return c.JSON(http.StatusOK, jsonData)
+
+	compact := false
+	if v := c.QueryParam("compact"); v != "" {
+		if compact, err = strconv.ParseBool(v); err != nil {
+			return Options{}, InvalidParam("compact", v, fmt.Errorf("not a boolean"), "true or false")
+		}
+	}
+
+	return Options{
+		Pantone: pantone,
+		Locale:  ResolveLocale(c.QueryParam("lang"), c.Request().Header.Get("Accept-Language")),
+		Fields:  fields,
+		Compact: compact,
+	}, nil
 }
 
+// normalizeHex returns "#rrggbb" in lower case from a hex color with or without #
+func normalizeHex(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s), "#"))
+	if !hexColor.MatchString(s) {
+		return "", false
+	}
+	return "#" + s, true
+}
+
+var hexColor = regexp.MustCompile(`^[0-9a-f]{6}$`)
+
 // getColor 
 // - constructs a new response object
 // - call and populate basic conversions
 // - call and populate advanced conversions
-// - call and populate color names
+// - call and populate color names and the descriptive name
 // - call and populate gradient
-func getColor(color string) (types.Response, error) {
+func getColor(color string, opts Options) (types.Response, error) {
 
 	var res = new(types.Response)
 
@@ -46,73 +137,169 @@ func getColor(color string) (types.Response, error) {
 		return types.Response{}, err
 	}
 
-	res.Base.Color.Name = GetColorName(&ref)
-	AddNames(&ref, res)
-	AddRAL(&ref, res)
-	AddPAN(&ref, res)
-	AddNCS(&ref, res)
-	AddMono(color, &ref, res)
+	if opts.Locale == "" {
+		opts.Locale = DefaultLocale
+	}
+	res.Locale = opts.Locale
+
+	// Only the selected sections are computed, see fields.go
+	fields := opts.Fields
+	if fields == 0 {
+		fields = AllFields
+	}
+
+	if fields.Has(FieldBase) {
+		res.Base.Color.Name = GetColorName(&ref, opts.Locale)
+		res.Base.Description = palette.Describe(colorful.Lab(ref.Lab.LAB[0], ref.Lab.LAB[1], ref.Lab.LAB[2]))
+	}
+	if fields.Has(FieldNames) {
+		AddNames(&ref, res, opts.Locale)
+	}
+	if fields.Has(FieldRAL) {
+		AddRAL(&ref, res)
+	}
+	if fields.Has(FieldPAN) {
+		AddPAN(&ref, res, opts.Pantone)
+	}
+	if fields.Has(FieldNCS) {
+		AddNCS(&ref, res)
+	}
+	if fields.Has(FieldMono) {
+		AddMono(color, &ref, res, opts.Locale)
+	}
 
 	return *res, nil
 }
 
 
-// HandleLookup GET /lookup
+// HandleLookup GET /v1/lookup (and GET /form, the path of the client feature branch)
 // This is a new feature to be launched at mycolorpicker.com, currently in testing
 // It route will not work without the client side feature branch
+// The locale of the session is picked on connect, from ?lang= or Accept-Language
+// The messages are JSON, see lookup.go
 
 func HandleLookup(c echo.Context) error {
-	websocket.Handler(func(ws *websocket.Conn) {
-		defer ws.Close()
+	return ServeSocket(c, "lookup", func(sock *Socket) {
+		locale := ResolveLocale(c.QueryParam("lang"), c.Request().Header.Get("Accept-Language"))
+		session := NewFormSession(locale)
+		session.Log = requestLog(c)
 
-		session := NewFormSession()
-		fmt.Println("Session initialized")
+		session.Log.Info("lookup session opened", "locale", locale)
+		session.Serve(sock)
+		session.Log.Info("lookup session closed")
+	})
+}
 
-		for {
-			// Read
-			msg := ""
-			err := websocket.Message.Receive(ws, &msg)
-			if err != nil {
-				fmt.Println("Read socket err", err) // add logging later
-				return
-			}
+// HandleColorStream GET /v1/colors/stream
+// Streams the getColor result of the colors sampled by the client, see stream.go
+// Optional query ?pantone=, ?lang=, ?fields= and ?compact= as for GET /colors/:hex, for the whole stream
+func HandleColorStream(c echo.Context) error {
+	opts, err := colorOptions(c)
+	if err != nil {
+		return err
+	}
 
-			fmt.Println("Message received: ", msg)
+	return ServeSocket(c, "stream", func(sock *Socket) {
+		log := requestLog(c)
+		log.Info("color stream opened", "locale", opts.Locale, "fields", opts.Fields.String(), "compact", opts.Compact)
+		StreamColors(sock, opts, log)
+		log.Info("color stream closed")
+	})
+}
 
-			if len(msg) > 1 {
+// HandleNameSearch GET /v1/names/search?q=&limit=&offset=
+// The HTTP equivalent of the lookup socket, with the same matching and ranking, see ColorLookUp
+// Optional query ?limit= (default 50, at most 500) and ?offset= page through the ranked matches
+// Optional query ?lang=sv (or the Accept-Language header) selects the locale of the names
+func HandleNameSearch(c echo.Context) error {
+	limit, err := intParam(c, "limit", lookupLimit)
+	if err == nil && (limit < 1 || limit > maxSearchLimit) {
+		err = fmt.Errorf("out of range")
+	}
+	if err != nil {
+		return InvalidParam("limit", c.QueryParam("limit"), err, "a number from 1 to 500")
+	}
+	offset, err := intParam(c, "offset", 0)
+	if err == nil && offset < 0 {
+		err = fmt.Errorf("out of range")
+	}
+	if err != nil {
+		return InvalidParam("offset", c.QueryParam("offset"), err, "a number from 0")
+	}
 
-				// // limit the number of msg to 50
-				// if len(session.LastResult.Names) > 50 {
-				// 	session.LastResult.Names = session.LastResult.Names[:50]
-				// }
+	locale := ResolveLocale(c.QueryParam("lang"), c.Request().Header.Get("Accept-Language"))
+	session := NewFormSession(locale)
 
-				Send(msg, session, ws)
-			}
+	ctx := c.Request().Context()
+	if err := ColorLookUp(ctx, c.QueryParam("q"), session); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err() // the client is gone
 		}
+		return InvalidQuery(c.QueryParam("q"), err)
+	}
 
-	}).ServeHTTP(c.Response(), c.Request())
-	return nil
+	return c.JSON(http.StatusOK, session.Result(offset, limit))
+}
+
+// SearchCost is the rate limit cost of GET /names/search, a token per page of 50 results
+func SearchCost(c echo.Context) int {
+	limit, err := intParam(c, "limit", lookupLimit)
+	if err != nil || limit < 1 {
+		return 1
+	}
+	return (min(limit, maxSearchLimit) + lookupLimit - 1) / lookupLimit
+}
+
+// maxSearchLimit is the largest page of GET /names/search
+const maxSearchLimit = 500
+
+// pantoneError reports a bad ?pantone= list
+func pantoneError(c echo.Context, err error) error {
+	return InvalidParam("pantone", c.QueryParam("pantone"), err, "a comma separated list of Pantone catalogs, currently pms")
 }
 
-func Send(query string, s *FormSession, socket *websocket.Conn) error {
+// intParam parses an optional integer query parameter
+func intParam(c echo.Context, name string, fallback int) (int, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("not a number")
+	}
+	return n, nil
+}
 
-	ColorLookUp(query, s)
+// lookupLimit is the number of names sent per lookup
+const lookupLimit = 50
 
-	if(len(s.LastResult) > 50) {
-		s.LastResult = s.LastResult[50:]
+func Send(ctx context.Context, query string, s *FormSession, socket *websocket.Conn) error {
+
+	err := ColorLookUp(ctx, query, s)
+	if ctx.Err() != nil {
+		return nil // superseded, the first protocol version has no cancelled reply
+	}
+
+	// Send the best matches only, the session keeps the full ranked result
+	// A malformed query has no matches, the raw socket protocol has no error message
+	result := s.Page(0, lookupLimit)
+	if err != nil {
+		s.Log.Debug("lookup error", "query", query, "err", err)
+		result = []ColorfulJson{}
 	}
 
-	// Marshal the LastResult.Names to JSON
-	data, err := json.Marshal(s.LastResult)
+	// Marshal the result to JSON
+	data, err := json.Marshal(result)
 	if err != nil {
-		fmt.Println("JSON marshaling error:", err)
+		s.Log.Error("JSON marshaling", "err", err)
 		return err
 	}
 
 	// Send the JSON data over the WebSocket
 	err = websocket.Message.Send(socket, string(data))
 	if err != nil {
-		fmt.Println("Write socket error:", err) // Add logging later
+		s.Log.Debug("write socket", "err", err)
 		return err
 	}
 	return nil
@@ -1,32 +1,40 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
+	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/codcodea/cc/palette"
 	t "github.com/codcodea/cc/types"
 
+	"github.com/kyroy/kdtree"
 	"github.com/lucasb-eyer/go-colorful"
 )
 
 // search.go holds the logic for searching and retrieving colors from the KD trees
 
+// goodNameSlack is how much further (CIEDE2000) than the nearest name a good name may be
+// and still be ranked before it, about one just noticeable difference
+const goodNameSlack = 0.01
+
 // AddNames finds the nearest 5 colors in the name tree and adds them to the response.
+// Good names close to the nearest name are ranked first, see rankNames.
 // - ref: the reference point (user input)
 // - res: i pointer to the response struct to be populated
-func AddNames(ref *t.CustomPoint, res *t.Response) {
-	nearest := Trees["NAM"].KNN(ref, 5)
-
-	for _, n := range nearest {
-		name := n.(t.CustomPoint).Name
-		res.Names = append(res.Names, name)
+// - locale: the name catalog to search, see locale.go
+func AddNames(ref *t.CustomPoint, res *t.Response, locale string) {
+	for _, n := range rankNames(ref, 5, locale) {
+		res.Names = append(res.Names, n.Name)
 	}
 }
 
 // RAL colors
 func AddRAL(ref *t.CustomPoint, res *t.Response) {
-	nearest := Trees["RAL"].KNN(ref, 1)
+	nearest := knn("RAL", ref, 1)
 
 	if len(nearest) > 0 {
 		res.Conversion.RAL.JSONRecord = extractToJson(nearest[0].(t.CustomPoint))
@@ -35,20 +43,38 @@ func AddRAL(ref *t.CustomPoint, res *t.Response) {
 }
 
 // PANTONE colors
-func AddPAN(ref *t.CustomPoint, res *t.Response) {
-	nearest := Trees["PAN"].KNN(ref, 2)
+// AddPAN searches each selected Pantone sub-catalog and keeps the closest match.
+// - variants: the sub-catalogs to search, e.g. ["pms"]
+func AddPAN(ref *t.CustomPoint, res *t.Response, variants []string) {
+	if len(variants) == 0 {
+		variants = DefaultPantone
+	}
+
+	found := false
+	for _, v := range variants {
+		tree := pantoneTree(v)
+		if _, ok := Trees[tree]; !ok {
+			continue
+		}
 
-	fmt.Println("PAN", nearest)
+		nearest := knn(tree, ref, 1)
+		if len(nearest) == 0 {
+			continue
+		}
 
-	if len(nearest) > 0 {
-		res.Conversion.PAN.JSONRecord = extractToJson(nearest[0].(t.CustomPoint))
-		res.Conversion.PAN.Distance = calDistance(ref, nearest[0].(t.CustomPoint))
+		distance := calDistance(ref, nearest[0].(t.CustomPoint))
+		if !found || distance < res.Conversion.PAN.Distance {
+			res.Conversion.PAN.JSONRecord = extractToJson(nearest[0].(t.CustomPoint))
+			res.Conversion.PAN.Distance = distance
+			res.Conversion.PAN.Catalog = v
+			found = true
+		}
 	}
 }
 
 // NCS colors
 func AddNCS(ref *t.CustomPoint, res *t.Response) {
-	nearest := Trees["NCS"].KNN(ref, 1)
+	nearest := knn("NCS", ref, 1)
 
 	if len(nearest) > 0 {
 		res.Conversion.NCS.JSONRecord = extractToJson(nearest[0].(t.CustomPoint))
@@ -60,18 +86,19 @@ func AddNCS(ref *t.CustomPoint, res *t.Response) {
 // - color: the reference color in HEX format
 // - ref: the reference point
 // - res: a pointer to the response struct to be populated
-func AddMono(color string, ref *t.CustomPoint, res *t.Response) {
+// - locale: the locale of the swatch names
+func AddMono(color string, ref *t.CustomPoint, res *t.Response, locale string) {
 	// NatrualGradient from /palette/
 	palette, err := palette.NatrualGradient(color)
 	if err != nil {
-		fmt.Println("Error:", err)
+		slog.Error("gradient", "color", color, "err", err)
 		return
 	}
 
 	for _, p := range palette.Gradient {
 		c := &t.Color{
 			Color: p.Hex,
-			Name:  GetColorName(&p.Lab),
+			Name:  GetColorName(&p.Lab, locale),
 		}
 		res.Mono = append(res.Mono, struct{ t.Color }{*c})
 	}
@@ -79,6 +106,14 @@ func AddMono(color string, ref *t.CustomPoint, res *t.Response) {
 
 // *** HELPER FUNCTIONS ***
 
+// knn searches the k nearest points of a catalog tree and records the search time, see metrics.go
+func knn(tree string, ref *t.CustomPoint, k int) []kdtree.Point {
+	start := time.Now()
+	nearest := Trees[tree].KNN(ref, k)
+	kdSearchSeconds.Observe(time.Since(start).Seconds(), tree)
+	return nearest
+}
+
 // calDistance calculates the distance between two colors in LAB color space
 // the distance is calculated using the CIEDE2000 algorithm
 // CIEDE2000 is industy standard for color difference and lets to end-user evaluate API results
@@ -90,7 +125,7 @@ func calDistance(ref *t.CustomPoint, nearest t.CustomPoint) float64 {
 	return c1.DistanceCIEDE2000(c2)
 }
 
-// helper function to extract name and color from data structures
+// helper function to extract name, color and metadata from data structures
 func extractToJson(nearest t.CustomPoint) t.JSONRecord {
 	name := nearest.Name
 	lab := nearest.Lab
@@ -101,23 +136,62 @@ func extractToJson(nearest t.CustomPoint) t.JSONRecord {
 	record.Lab.LABjson.A = lab.LAB[1]
 	record.Lab.LABjson.B = lab.LAB[2]
 
+	if nearest.Meta != nil {
+		record.Meta = *nearest.Meta
+	}
+
 	return *record
 }
 
 // GetColorName returns the name of the nearest color
 // is uses the same logic as AddNames but returns only the first result
-func GetColorName(ref *t.CustomPoint) string {
-	nearest := Trees["NAM"].KNN(ref, 1)
+func GetColorName(ref *t.CustomPoint, locale string) string {
+	nearest := rankNames(ref, 5, locale)
 	if len(nearest) > 0 {
-		return nearest[0].(t.CustomPoint).Name // cast *kdtree.Point to CustomPoint
+		return nearest[0].Name
 	}
 	return ""
 }
 
+// rankNames returns the k nearest names of a locale, good names within goodNameSlack of the nearest one first
+func rankNames(ref *t.CustomPoint, k int, locale string) []t.CustomPoint {
+	nearest := knn(nameTree(locale), ref, k)
+	if len(nearest) == 0 {
+		return nil
+	}
+
+	first := nearest[0].(t.CustomPoint) // cast *kdtree.Point to CustomPoint
+	limit := calDistance(ref, first) + goodNameSlack
+
+	preferred := []t.CustomPoint{}
+	others := []t.CustomPoint{}
+	for _, n := range nearest {
+		p := n.(t.CustomPoint)
+		if isGoodName(p) && calDistance(ref, p) <= limit {
+			preferred = append(preferred, p)
+		} else {
+			others = append(others, p)
+		}
+	}
+	return append(preferred, others...)
+}
+
 // Type definitions for the form session
 type ColorfulJson struct {
-	Name string `json:"name"`
-	Hex  string `json:"hex"`
+	Name string    `json:"name"`
+	Hex  string    `json:"hex"`
+	Lab  t.LABjson `json:"lab"`
+}
+
+// NameSearch is a page of ranked names, the response of GET /names/search and of the lookup socket
+type NameSearch struct {
+	Query     string         `json:"query"` // normalized query
+	Locale    string         `json:"locale"`
+	Total     int            `json:"total"`               // number of matches, across all pages
+	Truncated bool           `json:"truncated,omitempty"` // only the first maxSessionResults matches can be paged
+	Offset    int            `json:"offset"`
+	Limit     int            `json:"limit"`
+	Results   []ColorfulJson `json:"results"`
 }
 
 type FilterNames struct {
@@ -125,76 +199,262 @@ type FilterNames struct {
 }
 
 type FormSession struct {
+	Log        *slog.Logger // logger of the connection, with its request id
+	Locale     string       // name catalog of the session, see ResolveLocale
 	LastQuery  string
-	LastResult []ColorfulJson
+	LastLocale string         // locale of LastResult
+	LastResult []ColorfulJson // the first maxSessionResults matches of LastQuery, best first
+	LastTotal  int            // all matches of LastQuery
 }
 
-func NewFormSession() *FormSession {
+// maxSessionResults bounds the matches kept per session, the pages past it are empty (NameSearch.Truncated)
+const maxSessionResults = 2000
+
+func NewFormSession(locale string) *FormSession {
 	return &FormSession{
+		Log:        slog.Default(),
+		Locale:     locale,
 		LastQuery:  "",
 		LastResult: []ColorfulJson{},
 	}
 }
 
-func ColorLookUp(query string, session *FormSession) {
+// Page returns limit results of the last query starting at offset
+func (s *FormSession) Page(offset int, limit int) []ColorfulJson {
+	return page(s.LastResult, offset, limit)
+}
 
-	if len(query) < 2 {
-		return // ignore short queries
+// Result returns a page of the last query with its totals
+func (s *FormSession) Result(offset int, limit int) NameSearch {
+	return NameSearch{
+		Query:     s.LastQuery,
+		Locale:    s.Locale,
+		Total:     s.LastTotal,
+		Truncated: s.LastTotal > len(s.LastResult),
+		Offset:    offset,
+		Limit:     limit,
+		Results:   s.Page(offset, limit),
 	}
+}
 
-	query = strings.ToLower(query);
+// ColorLookUp searches the names of the session locale and stores the ranked matches on the session.
+// The query may filter by color semantics, e.g. "warm dark" or "hue:200-240 chroma:>30", see query.go.
+// A query shorter than 2 characters has no matches.
+// Repeating the last query in the same locale keeps its result, so paging through it is stable.
+// When ctx ends first, its error is returned and the session keeps the previous result.
+func ColorLookUp(ctx context.Context, query string, session *FormSession) error {
 
-	isExtended := strings.HasPrefix(query, session.LastQuery)
-	isEmpty := session.LastQuery == ""
+	query = normalizeQuery(query)
+	if query == session.LastQuery && session.Locale == session.LastLocale {
+		return nil
+	}
 
-	words := strings.Split(query, " ")
+	var matches []NameMatch
+	if len(query) >= 2 {
+		q, err := ParseQuery(query)
+		if err != nil {
+			return err
+		}
+		if matches, err = Indexes[session.Locale].Query(ctx, q); err != nil {
+			return err
+		}
+	}
 
-	// Check if the current query extends the previous query
-	if !isExtended || isEmpty {
+	session.LastResult = []ColorfulJson{}
+	session.LastTotal = len(matches)
+	for _, m := range matches[:min(len(matches), maxSessionResults)] {
+		c := Names[session.Locale][m.Index]
+		session.LastResult = append(session.LastResult, ColorfulJson{c.Name, c.Color.Hex(), c.Lab})
+	}
+	session.LastQuery, session.LastLocale = query, session.Locale
+	return nil
+}
 
-		session.LastResult = []ColorfulJson{}
+// *** NAME SEARCH RANKING ***
+
+// Match tiers, lower is better
+const (
+	TierExact     = iota // the name is the query
+	TierPrefix           // the name starts with the query
+	TierPhrase           // a word of the name starts the query, e.g. "sky blue" in "Deep Sky Blue"
+	TierWord             // every query word starts a word of the name
+	TierSubstring        // every query word is part of the name
+	TierFuzzy            // every query word is close to a word of the name (typo)
+	tierNone
+)
 
-		for _, c := range Names {
-			match := true
-			for _, w := range words {
-				if w == "" {
-					continue
-				}
-				if !strings.Contains(c.Name, w) {
-					match = false
-					break
-				}
-			}
+// NameMatch is a name matching a query, Index is the position in Names[locale]
+type NameMatch struct {
+	Index int
+	Tier  int
+	Edits int // total edit distance of the fuzzy words
+}
 
-			if match {
-				colorFul := ColorfulJson{c.Name, c.Color.Hex()}
-				session.LastResult = append(session.LastResult, colorFul)
-			}
+// rankMatches matches the query against all names, best first.
+// The server uses the inverted index (NameIndex.Search) with the same result, this scan is its reference.
+// Ties are broken by good names first, fewer typos, shorter names and finally the catalog order,
+// which makes the order total and the pagination stable.
+func rankMatches(query string, names []Colorful) []NameMatch {
+	words := strings.Fields(query)
+	matches := []NameMatch{}
+
+	for i := range names {
+		if m, ok := matchName(query, words, names[i].Lower); ok {
+			m.Index = i
+			matches = append(matches, m)
+		}
+	}
+
+	sortMatches(matches, names)
+	return matches
+}
+
+// sortMatches sorts matches by rank, see rankMatches
+func sortMatches(matches []NameMatch, names []Colorful) {
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Tier != b.Tier {
+			return a.Tier < b.Tier
+		}
+		if a.Edits != b.Edits {
+			return a.Edits < b.Edits
+		}
+		na, nb := names[a.Index], names[b.Index]
+		if na.Good != nb.Good {
+			return na.Good
+		}
+		if len(na.Lower) != len(nb.Lower) {
+			return len(na.Lower) < len(nb.Lower)
 		}
+		return a.Index < b.Index
+	})
+}
 
-		fmt.Println("!isExtended", len(session.LastResult))
+// matchName matches a normalized query and its words against a lower case name
+func matchName(query string, words []string, name string) (NameMatch, bool) {
+	if len(words) == 0 {
+		return NameMatch{}, false
+	}
+	if name == query {
+		return NameMatch{Tier: TierExact}, true
+	}
+	if strings.HasPrefix(name, query) {
+		return NameMatch{Tier: TierPrefix}, true
+	}
+	if strings.Contains(name, " "+query) {
+		return NameMatch{Tier: TierPhrase}, true
+	}
 
-	} else {
-		// Filter the previous result
-		filteredResult := []ColorfulJson{}
+	var nameWords []string // split lazily, most names fail on the substring check
+	m := NameMatch{Tier: TierWord}
 
-		for _, v := range session.LastResult {
-			match := true
-			for _, w := range words {
-				if w == "" {
-					continue
-				}
-				if !strings.Contains(v.Name, w) {
-					match = false
-					break
-				}
+	for _, w := range words {
+		tier := tierNone
+		if strings.Contains(name, w) {
+			tier = TierSubstring
+			if strings.HasPrefix(name, w) || strings.Contains(name, " "+w) {
+				tier = TierWord
 			}
+		} else {
+			if nameWords == nil {
+				nameWords = strings.Fields(name)
+			}
+			if edits, ok := fuzzyWord(w, nameWords); ok {
+				tier = TierFuzzy
+				m.Edits += edits
+			}
+		}
+
+		if tier == tierNone {
+			return NameMatch{}, false
+		}
+		m.Tier = max(m.Tier, tier)
+	}
+	return m, true
+}
+
+// maxEdits is the typo tolerance for a query word: none for short words, 1 from 4 letters, 2 from 8
+func maxEdits(word string) int {
+	n := utf8.RuneCountInString(word)
+	switch {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
 
-			if match {
-				filteredResult = append(filteredResult, v)
+// fuzzyWord returns the smallest edit distance of word to the words of a name, or to their prefixes
+// of the same length so partially typed words match, if within maxEdits
+func fuzzyWord(word string, nameWords []string) (int, bool) {
+	limit := maxEdits(word)
+	if limit == 0 {
+		return 0, false
+	}
+
+	best := limit + 1
+	w := []rune(word)
+	for _, nw := range nameWords {
+		r := []rune(nw)
+		if len(r) > len(w)+limit {
+			r = r[:len(w)+limit] // compare against the start of longer words
+		}
+		if d := levenshtein(w, r, best); d < best {
+			best = d
+		}
+	}
+	return best, best <= limit
+}
+
+// levenshtein returns the edit distance of a and b, or a value >= limit once it is exceeded
+func levenshtein(a []rune, b []rune, limit int) int {
+	if len(a)-len(b) >= limit {
+		return limit // b is too short, a prefix of it cannot help
+	}
+
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
 			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin >= limit {
+			return limit
 		}
-		session.LastResult = filteredResult
+		prev, curr = curr, prev
+	}
+
+	// b may be a prefix cut of a longer word, the best prefix of b counts
+	best := prev[len(b)]
+	for j := range prev {
+		best = min(best, prev[j])
+	}
+	return best
+}
+
+// normalizeQuery lower cases a query and collapses its whitespace
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// page returns limit items starting at offset, empty when out of range
+func page[T any](items []T, offset int, limit int) []T {
+	if offset < 0 || offset >= len(items) || limit <= 0 {
+		return []T{}
 	}
-	session.LastQuery = query
+	end := min(offset+limit, len(items))
+	return items[offset:end]
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestColorLookUpSession(t *testing.T) {
+	loadTestData(t)
+	ctx := context.Background()
+	s := NewFormSession(DefaultLocale)
+
+	lookup := func(query string) NameSearch {
+		t.Helper()
+		if err := ColorLookUp(ctx, query, s); err != nil {
+			t.Fatalf("%q: %v", query, err)
+		}
+		return s.Result(0, 5)
+	}
+
+	if r := lookup("Light Blue"); r.Total == 0 || r.Results[0].Name != "Light Blue" {
+		t.Fatalf("light blue: %+v", r)
+	}
+
+	// A short query replaces the previous result
+	for _, query := range []string{"b", " ", ""} {
+		if r := lookup(query); r.Total != 0 || len(r.Results) != 0 || r.Query != normalizeQuery(query) {
+			t.Errorf("%q: got the result of %q, %d matches", query, r.Query, r.Total)
+		}
+	}
+
+	// The same query in another locale is searched again
+	lookup("blå")
+	en := lookup("blå").Total
+	s.Locale = "sv"
+	if r := lookup("blå"); r.Locale != "sv" || r.Total == en || r.Results[0].Name != "Blå" {
+		t.Errorf("blå in sv: %+v", r)
+	}
+
+	// A cancelled search keeps the previous result
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := ColorLookUp(cancelled, "warm dark", s); err == nil {
+		t.Error("cancelled search: no error")
+	}
+	if s.LastQuery != "blå" {
+		t.Errorf("cancelled search replaced the result of %q", s.LastQuery)
+	}
+}
+
+func TestColorLookUpTruncated(t *testing.T) {
+	loadTestData(t)
+	s := NewFormSession(DefaultLocale)
+
+	if err := ColorLookUp(context.Background(), "is:dark", s); err != nil {
+		t.Fatal(err)
+	}
+	r := s.Result(maxSessionResults-1, 10)
+	if !r.Truncated || r.Total <= maxSessionResults || len(r.Results) != 1 {
+		t.Errorf("is:dark: truncated %v, total %d, %d results at the end", r.Truncated, r.Total, len(r.Results))
+	}
+
+	if err := ColorLookUp(context.Background(), "sea green", s); err != nil {
+		t.Fatal(err)
+	}
+	if r := s.Result(0, 10); r.Truncated || r.Total != len(s.LastResult) {
+		t.Errorf("sea green: truncated %v, total %d of %d kept", r.Truncated, r.Total, len(s.LastResult))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/labstack/echo/v4"
+)
+
+// server.go runs the HTTP server until SIGTERM (or SIGINT), then shuts it down gracefully:
+// - the listener stops accepting, the readiness probe fails so the orchestrator stops routing
+// - the websocket sessions are closed with a normal close frame, see socket.go
+// - the in-flight HTTP requests are drained, for at most the shutdown timeout
+
+// Serve runs app with the server settings of cfg until a termination signal
+func Serve(app *echo.Echo, cfg Config) error {
+	app.Server.ReadTimeout = cfg.ReadTimeout
+	app.Server.WriteTimeout = cfg.WriteTimeout
+	app.Server.IdleTimeout = cfg.IdleTimeout
+	app.TLSServer.ReadTimeout = cfg.ReadTimeout
+	app.TLSServer.WriteTimeout = cfg.WriteTimeout
+	app.TLSServer.IdleTimeout = cfg.IdleTimeout
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	failed := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", cfg.Addr, "tls", cfg.TLS())
+		var err error
+		if cfg.TLS() {
+			err = app.StartTLS(cfg.Addr, cfg.TLSCert, cfg.TLSKey)
+		} else {
+			err = app.Start(cfg.Addr)
+		}
+		failed <- err
+	}()
+
+	select {
+	case err := <-failed:
+		return err // the listener failed, Start never returns nil
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process
+
+	slog.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	loadState.ready.Store(false)
+
+	shutdown, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Hijacked connections are not tracked by http.Server, the sessions are closed here
+	closed := Sockets.CloseAll(shutdown)
+	if err := app.Shutdown(shutdown); err != nil {
+		return err
+	}
+	if err := <-failed; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if closed != nil {
+		return fmt.Errorf("closing websocket sessions: %w", closed)
+	}
+
+	slog.Info("server stopped")
+	return nil
+}
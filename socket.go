@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+	"golang.org/x/time/rate"
+)
+
+// socket.go holds the websocket sessions of the lookup socket and the color stream, and their limits:
+// - at most MaxSessions open sessions, a connection over it is answered 503 too_many_sessions before the upgrade
+// - the Origin of a browser must be allowed by -cors-origins, clients without Origin are not browsers
+// - a client message over MaxMessage bytes, or over the message rate of the session, is answered with an error and dropped
+// - every client message also takes a token of the rate limit of the client (ratelimit.go), over it it's dropped too.
+//   The color stream coalesces its samples instead: it has no message rate and only charges the samples it processes,
+//   see Socket.Coalesce
+// - the server pings the client every Heartbeat, a ping that can't be written ends the session
+// - a session without client message for IdleTimeout is closed
+// The sessions are tracked in Sockets, to close them on shutdown (server.go).
+
+// SocketLimits are the limits of the websocket sessions, see Config
+type SocketLimits struct {
+	MaxSessions int           // open sessions, 0 for no limit
+	MaxMessage  int           // bytes of a client message
+	Rate        float64       // client messages per second of a session, 0 for no limit
+	Burst       int           // messages a session may send at once
+	Heartbeat   time.Duration // ping interval, 0 disables the pings
+	IdleTimeout time.Duration // without client message, 0 for no timeout
+	Origins     []string      // allowed Origin headers, * allows any
+}
+
+// Sockets tracks the open websocket sessions
+var Sockets = &socketSet{conns: make(map[*Socket]struct{})}
+
+type socketSet struct {
+	limits SocketLimits
+
+	mu      sync.Mutex
+	conns   map[*Socket]struct{}
+	active  int // sessions, counted from before the upgrade
+	closing bool
+	done    sync.WaitGroup
+}
+
+// Socket is an open websocket session
+type Socket struct {
+	*websocket.Conn
+	name     string // lookup or stream
+	limits   SocketLimits
+	limiter  *rate.Limiter // of the client messages, nil for no limit
+	quota    *Quota        // rate limit of the client, nil if not limited
+	coalesce bool          // the handler coalesces the messages, see Coalesce
+	stop     chan struct{} // stops the heartbeat
+
+	mu     sync.Mutex
+	reason string // why the session ended, see disconnectReason
+}
+
+// Disconnect reasons
+const (
+	reasonClient    = "client"    // the client closed the socket
+	reasonIdle      = "idle"      // no client message for IdleTimeout
+	reasonHeartbeat = "heartbeat" // a ping failed, the client is gone
+	reasonShutdown  = "shutdown"  // the server shuts down
+	reasonError     = "error"     // the socket failed
+)
+
+var (
+	wsSessionsMax = newMetric("cc_websocket_sessions_max", "gauge",
+		"Limit of open websocket sessions, 0 for no limit.")
+	wsRejected = newMetric("cc_websocket_sessions_rejected_total", "counter",
+		"Websocket connections refused before the upgrade, by socket and reason (full, origin, shutdown).", "socket", "reason")
+	wsMessages = newMetric("cc_websocket_messages_total", "counter",
+		"Client messages received by socket.", "socket")
+	wsDropped = newMetric("cc_websocket_messages_rejected_total", "counter",
+		"Client messages dropped by socket and reason (too_large, rate_limited).", "socket", "reason")
+	wsDisconnects = newMetric("cc_websocket_disconnects_total", "counter",
+		"Closed websocket sessions by socket and reason (client, idle, heartbeat, shutdown, error).", "socket", "reason")
+)
+
+// Configure sets the limits of the sessions opened from now on
+func (s *socketSet) Configure(limits SocketLimits) {
+	s.mu.Lock()
+	s.limits = limits
+	s.mu.Unlock()
+	wsSessionsMax.Set(float64(limits.MaxSessions))
+}
+
+// ServeSocket upgrades the request to a websocket session and runs serve on it until the session ends
+func ServeSocket(c echo.Context, name string, serve func(sock *Socket)) error {
+	limits, err := Sockets.reserve(c, name)
+	if err != nil {
+		return err
+	}
+	defer Sockets.release()
+
+	wsSessions.Add(1, name)
+	defer wsSessions.Add(-1, name)
+
+	websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil }, // the origin is checked by reserve
+		Handler: func(ws *websocket.Conn) {
+			sock := Sockets.open(ws, name, limits)
+			if sock == nil {
+				ws.Close() // shutting down
+				return
+			}
+			sock.quota, _ = c.Get(quotaKey).(*Quota) // set by RateLimiter.Limit
+			defer Sockets.close(sock)
+			serve(sock)
+		},
+	}.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// Receive reads the next client message.
+// A message over the limits is dropped and returned as an *APIError, to answer before reading on,
+// any other error ends the session.
+func (s *Socket) Receive() (string, error) {
+	if s.limits.IdleTimeout > 0 {
+		s.SetReadDeadline(time.Now().Add(s.limits.IdleTimeout))
+	}
+
+	msg := ""
+	err := websocket.Message.Receive(s.Conn, &msg)
+	if errors.Is(err, websocket.ErrFrameTooLarge) {
+		wsDropped.Add(1, s.name, "too_large")
+		return "", NewError(http.StatusRequestEntityTooLarge, ErrTooLarge,
+			fmt.Sprintf("message over %d bytes", s.limits.MaxMessage), "", "")
+	}
+	if err != nil {
+		s.setReason(disconnectReason(err))
+		return "", err
+	}
+	wsMessages.Add(1, s.name)
+
+	if s.coalesce {
+		return msg, nil
+	}
+	if s.limiter != nil && !s.limiter.Allow() {
+		wsDropped.Add(1, s.name, "rate_limited")
+		return "", NewError(http.StatusTooManyRequests, ErrRateLimited,
+			fmt.Sprintf("more than %g messages per second", s.limits.Rate), "", "the message was dropped, send fewer")
+	}
+	if s.quota != nil {
+		if err := s.quota.Take(); err != nil {
+			wsDropped.Add(1, s.name, "rate_limited")
+			return "", err
+		}
+	}
+	return msg, nil
+}
+
+// Coalesce marks a session whose handler keeps only the latest message while busy.
+// Receive then neither limits the message rate nor charges the quota of the client,
+// the handler charges the messages it processes with Charge.
+func (s *Socket) Coalesce() {
+	s.coalesce = true
+}
+
+// Charge takes the token of a processed message from the quota of the client.
+// It reports false when the quota is exceeded, the message is then counted as dropped.
+func (s *Socket) Charge() bool {
+	if s.quota == nil || s.quota.Take() == nil {
+		return true
+	}
+	wsDropped.Add(1, s.name, "rate_limited")
+	return false
+}
+
+// CloseAll closes the sessions and waits for their handlers to return, or ctx to end
+func (s *socketSet) CloseAll(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	for sock := range s.conns {
+		sock.setReason(reasonShutdown)
+		sock.Close() // sends a normal close frame
+	}
+	n := len(s.conns)
+	s.mu.Unlock()
+
+	if n > 0 {
+		slog.Info("closing websocket sessions", "sessions", n)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.done.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// *** HELPER FUNCTIONS ***
+
+// reserve counts a session about to be upgraded, unless the limit is reached or the origin is not allowed
+func (s *socketSet) reserve(c echo.Context, name string) (SocketLimits, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limits := s.limits
+	origin := c.Request().Header.Get(echo.HeaderOrigin)
+
+	switch {
+	case s.closing:
+		wsRejected.Add(1, name, reasonShutdown)
+		return limits, NewError(http.StatusServiceUnavailable, ErrNotReady, "the server is shutting down", "", "reconnect shortly")
+	case origin != "" && !slices.Contains(limits.Origins, "*") && !slices.Contains(limits.Origins, origin):
+		wsRejected.Add(1, name, "origin")
+		return limits, NewError(http.StatusForbidden, ErrOriginDenied, "origin not allowed", origin, "")
+	case limits.MaxSessions > 0 && s.active >= limits.MaxSessions:
+		wsRejected.Add(1, name, "full")
+		return limits, NewError(http.StatusServiceUnavailable, ErrTooManySessions,
+			fmt.Sprintf("all %d websocket sessions are in use", limits.MaxSessions), "", "reconnect later")
+	}
+
+	s.active++
+	return limits, nil
+}
+
+func (s *socketSet) release() {
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+}
+
+// open tracks an upgraded session and starts its heartbeat, it returns nil when shutting down
+func (s *socketSet) open(ws *websocket.Conn, name string, limits SocketLimits) *Socket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return nil
+	}
+
+	sock := &Socket{Conn: ws, name: name, limits: limits, stop: make(chan struct{})}
+	if limits.MaxMessage > 0 {
+		ws.MaxPayloadBytes = limits.MaxMessage
+	}
+	if limits.Rate > 0 {
+		sock.limiter = rate.NewLimiter(rate.Limit(limits.Rate), max(limits.Burst, 1))
+	}
+	if limits.Heartbeat > 0 {
+		go sock.heartbeat()
+	}
+
+	s.conns[sock] = struct{}{}
+	s.done.Add(1)
+	return sock
+}
+
+// close untracks a session that ended and closes its socket
+func (s *socketSet) close(sock *Socket) {
+	close(sock.stop)
+	sock.Close()
+
+	sock.mu.Lock()
+	reason := sock.reason
+	sock.mu.Unlock()
+	if reason == "" {
+		reason = reasonClient
+	}
+	wsDisconnects.Add(1, sock.name, reason)
+
+	s.mu.Lock()
+	delete(s.conns, sock)
+	s.mu.Unlock()
+	s.done.Done()
+}
+
+// heartbeat pings the client until the session ends.
+// x/net/websocket has no ping API, Write sends a frame of ws.PayloadType under the lock of the writes,
+// the replies are sent with websocket.JSON which sets its own frame type. The pongs are handled by the library.
+func (s *Socket) heartbeat() {
+	s.PayloadType = websocket.PingFrame
+
+	t := time.NewTicker(s.limits.Heartbeat)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+		}
+
+		// A reply stuck for two intervals fails too, the client is gone
+		s.SetWriteDeadline(time.Now().Add(2 * s.limits.Heartbeat))
+		if _, err := s.Write(nil); err != nil {
+			s.setReason(reasonHeartbeat)
+			s.Close() // ends the read of the session
+			return
+		}
+	}
+}
+
+// setReason records why the session ended, the first reason wins
+func (s *Socket) setReason(reason string) {
+	s.mu.Lock()
+	if s.reason == "" {
+		s.reason = reason
+	}
+	s.mu.Unlock()
+}
+
+// disconnectReason tells why a read of the socket failed
+func disconnectReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, io.EOF):
+		return reasonClient
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return reasonIdle
+	default:
+		return reasonError
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// stream.go holds the live color stream of the EyeDropper flow (GET /colors/stream).
+// While the user hovers the screen the client streams the sampled colors over one socket,
+// instead of an HTTP request per mouse move:
+//
+//	-> {"type":"sample","id":41,"color":"2f4f4f"}   (or just "2f4f4f" / "#2f4f4f")
+//	<- {"type":"color","id":41,"dropped":3,"color":{...same as GET /colors/:hex...}}
+//	<- {"type":"error","id":41,"error":{"code":"invalid_color","message":"color must be 6 hex digits","input":"2f4f4",...}}
+//
+// Samples are coalesced: while a sample is processed only the latest incoming one is kept,
+// the skipped ones are counted in "dropped" of the next reply and get no reply of their own.
+// A sample repeating the last processed color is answered with the previous result, without a lookup.
+// The samples are not limited by the message rate of the session (-ws-rate), coalescing bounds the work.
+// Only the processed samples take a token of the rate limit of the client, over it a sample is
+// dropped without a reply and counted in "dropped" of the next one.
+// The options of getColor are set on connect, e.g. /colors/stream?fields=base,pan&lang=sv&compact=true
+
+// streamHint is the hint of the errors of malformed messages
+const streamHint = `send {"type":"sample","id":1,"color":"2f4f4f"} or just the hex`
+
+// Message types of the color stream
+const (
+	MsgSample = "sample" // client: a sampled color
+	MsgColor  = "color"  // server: the getColor result of a sample
+)
+
+// ColorSample is a client message of the color stream
+type ColorSample struct {
+	Type  string          `json:"type"`
+	ID    json.RawMessage `json:"id,omitempty"` // any JSON value, echoed in the reply
+	Color string          `json:"color"`        // hex, with or without #
+}
+
+// StreamReply is a server message of the color stream
+type StreamReply struct {
+	Type    string          `json:"type"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Dropped int             `json:"dropped,omitempty"` // samples skipped since the previous reply
+	Color   any             `json:"color,omitempty"`   // types.Response, shaped by ?fields= and ?compact=
+	Error   *APIError       `json:"error,omitempty"`   // the error envelope, see errors.go
+}
+
+// sampleSlot holds the latest sample not processed yet
+type sampleSlot struct {
+	mu      sync.Mutex
+	sample  *ColorSample
+	dropped int
+	ready   chan struct{} // signals a sample, buffered 1
+}
+
+// StreamColors runs the color stream on a socket until the client disconnects
+func StreamColors(sock *Socket, opts Options, log *slog.Logger) {
+	ws := sock.Conn
+	slot := &sampleSlot{ready: make(chan struct{}, 1)}
+	done := make(chan struct{})
+	defer close(done)
+
+	sock.Coalesce() // the samples are charged by processSamples
+	go processSamples(sock, slot, opts, done)
+
+	for {
+		msg, err := sock.Receive()
+		var rejected *APIError
+		if errors.As(err, &rejected) {
+			sendStream(ws, StreamReply{Type: MsgError, Error: rejected})
+			continue
+		}
+		if err != nil {
+			log.Debug("read socket", "err", err)
+			return
+		}
+
+		s := ColorSample{Type: MsgSample, Color: msg}
+		if strings.HasPrefix(strings.TrimSpace(msg), "{") {
+			s = ColorSample{}
+			if err := json.Unmarshal([]byte(msg), &s); err != nil {
+				sendStream(ws, StreamReply{Type: MsgError, Error: InvalidMessage("malformed message: "+err.Error(), msg, streamHint)})
+				continue
+			}
+		}
+		if s.Type != MsgSample {
+			sendStream(ws, StreamReply{Type: MsgError, ID: s.ID, Error: InvalidMessage(fmt.Sprintf("unknown message type %q", s.Type), msg, streamHint)})
+			continue
+		}
+
+		slot.mu.Lock()
+		if slot.sample != nil {
+			slot.dropped++
+		}
+		slot.sample = &s
+		slot.mu.Unlock()
+
+		select {
+		case slot.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// processSamples answers the latest sample of the slot, one at a time
+func processSamples(sock *Socket, slot *sampleSlot, opts Options, done chan struct{}) {
+	ws := sock.Conn
+	last, lastColor := "", json.RawMessage(nil) // the last processed color and its result
+	skipped := 0                                // samples over the quota since the last reply
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-slot.ready:
+		}
+
+		slot.mu.Lock()
+		s, dropped := slot.sample, slot.dropped
+		slot.sample, slot.dropped = nil, 0
+		slot.mu.Unlock()
+
+		if s == nil {
+			continue
+		}
+		if !sock.Charge() {
+			skipped += dropped + 1
+			continue
+		}
+		dropped, skipped = dropped+skipped, 0
+
+		hex, ok := normalizeHex(s.Color)
+		if !ok {
+			sendStream(ws, StreamReply{Type: MsgError, ID: s.ID, Dropped: dropped, Error: InvalidColor(s.Color)})
+			continue
+		}
+		if hex != last {
+			cached, _, err := colorJSON(hex, opts)
+			if err != nil {
+				sendStream(ws, StreamReply{Type: MsgError, ID: s.ID, Dropped: dropped, Error: InternalError(err)})
+				continue
+			}
+			last, lastColor = hex, json.RawMessage(cached.Body)
+		}
+
+		sendStream(ws, StreamReply{Type: MsgColor, ID: s.ID, Dropped: dropped, Color: lastColor})
+	}
+}
+
+// *** HELPER FUNCTIONS ***
+
+// sendStream sends a message, the socket serializes concurrent writes
+func sendStream(ws *websocket.Conn, r StreamReply) {
+	if err := websocket.JSON.Send(ws, r); err != nil {
+		slog.Debug("write socket", "err", err)
+	}
+}
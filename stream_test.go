@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+// streamServer serves the color stream behind the rate limit of cfg
+func streamServer(t *testing.T, cfg Config) *httptest.Server {
+	t.Helper()
+	loadTestData(t)
+	limiter, err := NewRateLimiter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Sockets.Configure(cfg.SocketLimits())
+
+	app := echo.New()
+	app.HTTPErrorHandler = HandleError
+	app.IPExtractor = cfg.IPExtractor()
+	app.GET("/colors/stream", HandleColorStream, limiter.Limit(UnitCost))
+
+	srv := httptest.NewServer(app)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// readReplies reads the stream replies until none comes for the wait
+func readReplies(ws *websocket.Conn, wait time.Duration) []StreamReply {
+	replies := []StreamReply{}
+	for {
+		ws.SetReadDeadline(time.Now().Add(wait))
+		var r StreamReply
+		if err := websocket.JSON.Receive(ws, &r); err != nil {
+			return replies
+		}
+		replies = append(replies, r)
+	}
+}
+
+func TestStreamQuota(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RateLimit, cfg.RateBurst = 0.001, 6 // the connection takes one token
+	srv := streamServer(t, cfg)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/colors/stream"
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// A hover at 60 Hz, far over the quota
+	const samples = 60
+	for i := 0; i < samples; i++ {
+		websocket.Message.Send(ws, []string{"#112233", "#445566"}[i%2])
+		time.Sleep(time.Second / 60)
+	}
+
+	replies := readReplies(ws, 500*time.Millisecond)
+	answered := 0
+	for _, r := range replies {
+		if r.Type != MsgColor {
+			t.Fatalf("reply %+v, want colors only", r)
+		}
+		answered += 1 + r.Dropped
+	}
+	if len(replies) != cfg.RateBurst-1 {
+		t.Errorf("%d replies, want one per token left", len(replies))
+	}
+
+	// The samples over the quota get no reply, they are reported in dropped
+	if answered > samples {
+		t.Errorf("%d samples answered or dropped, %d sent", answered, samples)
+	}
+}
+
+func TestStreamNoMessageRate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RateLimit = 0
+	cfg.WSRate, cfg.WSBurst = 1, 1 // would reject nearly every sample
+	srv := streamServer(t, cfg)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/colors/stream"
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	const samples = 30
+	for i := 0; i < samples; i++ {
+		websocket.Message.Send(ws, []string{"#112233", "#445566"}[i%2])
+	}
+
+	// Every sample is either answered or coalesced into the next reply, none is an error
+	answered := 0
+	for _, r := range readReplies(ws, 500*time.Millisecond) {
+		if r.Type != MsgColor {
+			t.Fatalf("reply %+v, want colors only", r)
+		}
+		answered += 1 + r.Dropped
+	}
+	if answered != samples {
+		t.Errorf("%d samples answered or dropped, %d sent", answered, samples)
+	}
+}
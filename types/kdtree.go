@@ -24,6 +24,23 @@ type JSONRecord struct {
 	Lab  struct {
 		LABjson
 	} `json:"lab"`
+	Meta
+}
+
+// Meta holds the optional catalog metadata of a color record.
+// It is embedded in JSONRecord, so the fields are flat in the catalog JSON and the API response.
+type Meta struct {
+	Code    string   `json:"code,omitempty"`
+	Hex     string   `json:"hex,omitempty"`
+	Src     string   `json:"src,omitempty"`
+	License string   `json:"license,omitempty"`
+	Aliases []string `json:"aliases,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// IsZero reports whether no metadata is set
+func (m Meta) IsZero() bool {
+	return m.Code == "" && m.Hex == "" && m.Src == "" && m.License == "" && len(m.Aliases) == 0 && len(m.Tags) == 0
 }
 
 
@@ -34,9 +51,20 @@ func NewPoint(name string, p [3]float64) CustomPoint {
 	}
 }
 
+// NewRecordPoint creates a point from a catalog record, keeping its metadata
+func NewRecordPoint(record JSONRecord) CustomPoint {
+	point := NewPoint(record.Name, [3]float64{record.Lab.L, record.Lab.A, record.Lab.B})
+	if !record.Meta.IsZero() {
+		meta := record.Meta
+		point.Meta = &meta
+	}
+	return point
+}
+
 type CustomPoint struct {
 	Name string
 	Lab  LAB
+	Meta *Meta // nil for reference points and records without metadata
 }
 
 func (p CustomPoint) Dimensions() int {
@@ -8,34 +8,84 @@ type Color struct {
 }
 
 type Response struct {
-	Base struct {
-		Color 
-	} `json:"base"`
+	Base BaseSection `json:"base"`
 
-	Mono []struct {
-		Color 
-	} `json:"mono"`
+	Mono []MonoStep `json:"mono"`
 
 	Names []string `json:"names"`
 
+	Locale string `json:"locale"` // locale of the name catalog used for all names
+
 	Conversion struct {
-		RGB  string `json:"rgb"`
-		HSL  string `json:"hsl"`
-		HSV  string `json:"hsv"`
-		LAB  string `json:"lab"`
-		CMYK string `json:"cmyk"`
-		RAL  struct {
-			JSONRecord
-			Distance float64 `json:"distance"`
-		} `json:"ral"`
-		PAN struct {
-			JSONRecord
-			Distance float64 `json:"distance"`
-		} `json:"pan"`
-		NCS struct {
-			JSONRecord
-			Distance float64 `json:"distance"`
-		} `json:"ncs"`
+		RGB  string       `json:"rgb"`
+		HSL  string       `json:"hsl"`
+		HSV  string       `json:"hsv"`
+		LAB  string       `json:"lab"`
+		CMYK string       `json:"cmyk"`
+		RAL  CatalogMatch `json:"ral"`
+		PAN  PantoneMatch `json:"pan"`
+		NCS  CatalogMatch `json:"ncs"`
 	} `json:"conversions"`
 }
 
+// The sections of Response are aliases of unnamed structs, so Response keeps its shape
+// and Partial shares them.
+
+type BaseSection = struct {
+	Color
+	Description string `json:"description"` // generated descriptive name, e.g. "dark desaturated blue-green"
+}
+
+type MonoStep = struct {
+	Color
+}
+
+// CatalogMatch is the nearest record of a catalog
+type CatalogMatch = struct {
+	JSONRecord
+	Distance float64 `json:"distance"`
+}
+
+// PantoneMatch is the nearest record of the selected Pantone catalogs
+type PantoneMatch = struct {
+	JSONRecord
+	Distance float64 `json:"distance"`
+	Catalog  string  `json:"catalog"` // Pantone sub-catalog of the match, e.g. "pms"
+}
+
+// Partial is the response of ?fields= selecting some sections of Response, the others are omitted
+type Partial struct {
+	Base        *BaseSection       `json:"base,omitempty"`
+	Mono        []MonoStep         `json:"mono,omitempty"`
+	Names       []string           `json:"names,omitempty"`
+	Locale      string             `json:"locale"`
+	Conversions *PartialConversion `json:"conversions,omitempty"`
+}
+
+// PartialConversion holds the selected conversions and catalog matches of a Partial
+type PartialConversion struct {
+	RGB  string        `json:"rgb,omitempty"`
+	HSL  string        `json:"hsl,omitempty"`
+	HSV  string        `json:"hsv,omitempty"`
+	LAB  string        `json:"lab,omitempty"`
+	CMYK string        `json:"cmyk,omitempty"`
+	RAL  *CatalogMatch `json:"ral,omitempty"`
+	PAN  *PantoneMatch `json:"pan,omitempty"`
+	NCS  *CatalogMatch `json:"ncs,omitempty"`
+}
+
+
+// Compact is the flat response of ?compact=true, the catalog matches are reduced to their codes.
+// Sections not selected by ?fields= are omitted.
+type Compact struct {
+	Color       string   `json:"color"`
+	Locale      string   `json:"locale"`
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Names       []string `json:"names,omitempty"`
+	Mono        []string `json:"mono,omitempty"` // hex of the gradient
+	RGB         string   `json:"rgb,omitempty"`
+	RAL         string   `json:"ral,omitempty"`
+	PAN         string   `json:"pan,omitempty"`
+	NCS         string   `json:"ncs,omitempty"`
+}